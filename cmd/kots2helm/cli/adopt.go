@@ -0,0 +1,85 @@
+package cli
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots2helm/pkg/adopt"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+func AdoptCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "adopt [chart]",
+		Short:        "move the objects of a running kots install under the ownership of a helm release",
+		Long:         `Renders the chart with the migrated values and finds the matching live objects. Each object is patched with the helm ownership labels and annotations so that helm install can adopt it. Objects that would change are refused.`,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			viper.BindPFlags(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			v := viper.GetViper()
+
+			loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+			loadingRules.ExplicitPath = v.GetString("kubeconfig")
+			overrides := &clientcmd.ConfigOverrides{CurrentContext: v.GetString("kube-context")}
+			clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+
+			namespace := v.GetString("namespace")
+			if namespace == "" {
+				ns, _, err := clientConfig.Namespace()
+				if err != nil {
+					return errors.Wrap(err, "failed to get namespace")
+				}
+				namespace = ns
+			}
+
+			restConfig, err := clientConfig.ClientConfig()
+			if err != nil {
+				return errors.Wrap(err, "failed to get kubernetes config")
+			}
+			discoveryClient, err := discovery.NewDiscoveryClientForConfig(restConfig)
+			if err != nil {
+				return errors.Wrap(err, "failed to create discovery client")
+			}
+			dynamicClient, err := dynamic.NewForConfig(restConfig)
+			if err != nil {
+				return errors.Wrap(err, "failed to create dynamic client")
+			}
+
+			clients := adopt.Clients{
+				Discovery: discoveryClient,
+				Dynamic:   dynamicClient,
+				Config:    restConfig,
+			}
+			opts := adopt.AdoptOpts{
+				ChartPath:   args[0],
+				ValuesFiles: v.GetStringSlice("values"),
+				ReleaseName: v.GetString("release-name"),
+				Namespace:   namespace,
+				DryRun:      v.GetBool("dry-run"),
+				Out:         os.Stdout,
+			}
+			if err := adopt.Adopt(cmd.Context(), clients, opts); err != nil {
+				return err
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("release-name", "", "name of the helm release that will own the objects")
+	cmd.MarkFlagRequired("release-name")
+	cmd.Flags().StringP("namespace", "n", "", "namespace of the helm release. defaults to the namespace of the kube context")
+	cmd.Flags().StringSliceP("values", "f", nil, "values files to render the chart with, such as the output of values from-configvalues")
+	cmd.Flags().Bool("dry-run", false, "print the patches as a shell script instead of applying them")
+	cmd.Flags().String("kubeconfig", "", "path to the kubeconfig file")
+	cmd.Flags().String("kube-context", "", "name of the kubeconfig context to use")
+
+	return cmd
+}
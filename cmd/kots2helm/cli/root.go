@@ -3,6 +3,7 @@ package cli
 import (
 	"fmt"
 	"os"
+	"runtime"
 	"strings"
 
 	"github.com/replicatedhq/kots2helm/pkg/builder"
@@ -32,7 +33,22 @@ func RootCmd() *cobra.Command {
 				logger.SetVerbose()
 			}
 
-			if err := builder.Build(args[0], v.GetString("name"), v.GetString("version")); err != nil {
+			opts := builder.BuildOpts{
+				Strict:         v.GetBool("strict"),
+				OnUnconverted:  v.GetString("on-unconverted"),
+				CacheDir:       v.GetString("cache-dir"),
+				Concurrency:    v.GetInt("concurrency"),
+				PrefixNames:    v.GetBool("prefix-names"),
+				AppNamespaces:  v.GetStringSlice("app-namespace"),
+				ValuesNaming:   v.GetString("values-naming"),
+				ValuesLayout:   v.GetString("values-layout"),
+				ValuesMapFile:  v.GetString("values-map"),
+				ValuesLockFile: v.GetString("values-lock"),
+			}
+			if !v.IsSet("values-lock") {
+				opts.ValuesLockFile = builder.DefaultValuesLockFile(args[0])
+			}
+			if err := builder.Build(args[0], v.GetString("name"), v.GetString("version"), opts); err != nil {
 				return err
 			}
 
@@ -45,6 +61,20 @@ func RootCmd() *cobra.Command {
 	cmd.MarkFlagRequired("name")
 	cmd.Flags().String("version", "", "version of the helm chart to build")
 	cmd.MarkFlagRequired("version")
+	cmd.Flags().Bool("strict", false, "fail when any kots template function could not be converted, the same as --on-unconverted fail, and treat lint warnings as validation failures")
+	cmd.Flags().String("cache-dir", "", "directory to cache converted files in, so that unchanged files are reused between runs")
+	cmd.Flags().Int("concurrency", runtime.NumCPU(), "number of files to convert at the same time")
+	cmd.Flags().String("on-unconverted", string(builder.UnconvertedPolicyKeep), "what to do with kots template functions that could not be converted: comment, fail or keep")
+	cmd.Flags().Bool("prefix-names", false, "prefix resource names with the release fullname and rewrite the references to them")
+	cmd.Flags().StringSlice("app-namespace", nil, "namespace the app was installed to, references to it are changed to the release namespace. by default it's the namespace most manifests are in, and no namespace is changed when there isn't one")
+	cmd.Flags().String("values-naming", string(builder.ValuesNamingIndex), "how config group and item names become values keys: index keeps them and reads names with dashes with index, camelCase converts them to camelCase")
+	cmd.Flags().String("values-layout", string(builder.ValuesLayoutGrouped), "where config items are put in the values: grouped puts them under their group, flat puts them at the top level")
+	cmd.Flags().String("values-map", "", "yaml file that maps config item names to values paths, like postgres_password: postgresql.auth.password")
+	cmd.Flags().String("values-lock", "", "file that keeps the values path of each config item stable between builds. it's read if it exists and written after each build, values-map.lock next to the input dir by default, not used when empty")
+
+	cmd.AddCommand(WatchCmd())
+	cmd.AddCommand(ValuesCmd())
+	cmd.AddCommand(AdoptCmd())
 
 	cobra.OnInitialize(initConfig)
 
@@ -0,0 +1,91 @@
+package cli
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots2helm/pkg/builder"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func ValuesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "values",
+		Short: "convert between kots config values and helm values",
+		Long:  ``,
+	}
+
+	cmd.AddCommand(valuesConvertCmd(
+		"from-configvalues [configvalues.yaml]",
+		"convert a kots ConfigValues to a helm values file",
+		builder.ConfigValuesToHelmValues,
+	))
+	cmd.AddCommand(valuesConvertCmd(
+		"to-configvalues [values.yaml]",
+		"convert a helm values file to a kots ConfigValues",
+		builder.HelmValuesToConfigValues,
+	))
+
+	return cmd
+}
+
+func valuesConvertCmd(use string, short string, convert func([]byte, []byte, builder.ValuesOpts) ([]byte, []string, error)) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          use,
+		Short:        short,
+		Long:         ``,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			viper.BindPFlags(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			v := viper.GetViper()
+
+			config, err := ioutil.ReadFile(v.GetString("config"))
+			if err != nil {
+				return errors.Wrap(err, "failed to read config")
+			}
+			input, err := ioutil.ReadFile(args[0])
+			if err != nil {
+				return errors.Wrapf(err, "failed to read %s", args[0])
+			}
+
+			valuesOpts, err := builder.GetValuesOpts(v.GetString("values-naming"), v.GetString("values-layout"), v.GetString("values-map"), v.GetString("values-lock"))
+			if err != nil {
+				return err
+			}
+
+			converted, warnings, err := convert(config, input, valuesOpts)
+			if err != nil {
+				return err
+			}
+			for _, warning := range warnings {
+				fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+			}
+
+			if v.GetString("output") == "" {
+				fmt.Print(string(converted))
+				return nil
+			}
+			if err := ioutil.WriteFile(v.GetString("output"), converted, 0644); err != nil {
+				return errors.Wrap(err, "failed to write output")
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("config", "", "the kots config the chart was built from")
+	cmd.MarkFlagRequired("config")
+	cmd.Flags().StringP("output", "o", "", "file to write to, stdout when empty")
+	cmd.Flags().String("values-naming", string(builder.ValuesNamingIndex), "the --values-naming the chart was built with: index or camelCase")
+	cmd.Flags().String("values-layout", string(builder.ValuesLayoutGrouped), "the --values-layout the chart was built with: grouped or flat")
+	cmd.Flags().String("values-map", "", "the --values-map the chart was built with")
+	cmd.Flags().String("values-lock", "", "the values-map.lock written when the chart was built, next to its input dir. not used when empty or when it doesn't exist")
+
+	return cmd
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"os"
+	"os/signal"
+	"runtime"
+	"syscall"
+
+	"github.com/replicatedhq/kots2helm/pkg/builder"
+	"github.com/replicatedhq/kots2helm/pkg/logger"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func WatchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:          "watch [dir]",
+		Short:        "keep a helm chart in sync with a directory of kots manifests",
+		Long:         ``,
+		SilenceUsage: true,
+		Args:         cobra.ExactArgs(1),
+		PreRun: func(cmd *cobra.Command, args []string) {
+			viper.BindPFlags(cmd.Flags())
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			v := viper.GetViper()
+
+			if v.GetString("log-level") == "debug" {
+				logger.Info("setting log level to debug")
+				logger.SetDebug()
+			} else if v.GetString("log-level") == "verbose" {
+				logger.Info("setting log level to verbose")
+				logger.SetVerbose()
+			}
+
+			ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			opts := builder.BuildOpts{
+				Strict:         v.GetBool("strict"),
+				OnUnconverted:  v.GetString("on-unconverted"),
+				CacheDir:       v.GetString("cache-dir"),
+				Concurrency:    v.GetInt("concurrency"),
+				PrefixNames:    v.GetBool("prefix-names"),
+				AppNamespaces:  v.GetStringSlice("app-namespace"),
+				ValuesNaming:   v.GetString("values-naming"),
+				ValuesLayout:   v.GetString("values-layout"),
+				ValuesMapFile:  v.GetString("values-map"),
+				ValuesLockFile: v.GetString("values-lock"),
+			}
+			if !v.IsSet("values-lock") {
+				opts.ValuesLockFile = builder.DefaultValuesLockFile(args[0])
+			}
+			if err := builder.Watch(ctx, args[0], v.GetString("output-dir"), v.GetString("name"), v.GetString("version"), opts); err != nil {
+				return err
+			}
+
+			return nil
+		},
+	}
+
+	cmd.Flags().String("log-level", "info", "log level")
+	cmd.Flags().String("output-dir", "", "directory to write the unpacked helm chart to")
+	cmd.MarkFlagRequired("output-dir")
+	cmd.Flags().String("name", "", "name of the helm chart to build")
+	cmd.MarkFlagRequired("name")
+	cmd.Flags().String("version", "", "version of the helm chart to build")
+	cmd.MarkFlagRequired("version")
+	cmd.Flags().Bool("strict", false, "fail when any kots template function could not be converted, the same as --on-unconverted fail, and treat lint warnings as validation failures")
+	cmd.Flags().String("cache-dir", "", "directory to cache converted files in, so that unchanged files are reused between runs")
+	cmd.Flags().Int("concurrency", runtime.NumCPU(), "number of files to convert at the same time")
+	cmd.Flags().String("on-unconverted", string(builder.UnconvertedPolicyKeep), "what to do with kots template functions that could not be converted: comment, fail or keep")
+	cmd.Flags().Bool("prefix-names", false, "prefix resource names with the release fullname and rewrite the references to them")
+	cmd.Flags().StringSlice("app-namespace", nil, "namespace the app was installed to, references to it are changed to the release namespace. by default it's the namespace most manifests are in, and no namespace is changed when there isn't one")
+	cmd.Flags().String("values-naming", string(builder.ValuesNamingIndex), "how config group and item names become values keys: index keeps them and reads names with dashes with index, camelCase converts them to camelCase")
+	cmd.Flags().String("values-layout", string(builder.ValuesLayoutGrouped), "where config items are put in the values: grouped puts them under their group, flat puts them at the top level")
+	cmd.Flags().String("values-map", "", "yaml file that maps config item names to values paths, like postgres_password: postgresql.auth.password")
+	cmd.Flags().String("values-lock", "", "file that keeps the values path of each config item stable between builds. it's read if it exists and written after each build, values-map.lock next to the input dir by default, not used when empty")
+
+	return cmd
+}
@@ -0,0 +1,536 @@
+package adopt
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/releaseutil"
+	kuberneteserrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/restmapper"
+)
+
+const (
+	managedByLabel             = "app.kubernetes.io/managed-by"
+	releaseNameAnnotation      = "meta.helm.sh/release-name"
+	releaseNamespaceAnnotation = "meta.helm.sh/release-namespace"
+)
+
+// AdoptOpts are the options for adopting the live objects of a chart
+type AdoptOpts struct {
+	// ChartPath is the packaged or unpacked chart
+	ChartPath string
+	// ValuesFiles are merged in order, the same as helm install -f
+	ValuesFiles []string
+	ReleaseName string
+	Namespace   string
+	// DryRun prints the patches as a script instead of applying them
+	DryRun bool
+	Out    io.Writer
+}
+
+// Clients are the kubernetes clients used to find and patch the live objects
+type Clients struct {
+	Discovery discovery.DiscoveryInterface
+	Dynamic   dynamic.Interface
+	// Config is used by lookup when the chart is rendered. lookup returns nothing when it's nil
+	Config *rest.Config
+}
+
+type adoptionState string
+
+const (
+	// adoptionStateNew objects don't exist and will be created by helm install
+	adoptionStateNew adoptionState = "new"
+	// adoptionStateAdopt objects exist and will be patched with the helm ownership metadata
+	adoptionStateAdopt adoptionState = "adopt"
+	// adoptionStateOwned objects are already owned by the release
+	adoptionStateOwned adoptionState = "owned"
+	// adoptionStateRefused objects can't be adopted
+	adoptionStateRefused adoptionState = "refused"
+)
+
+type adoption struct {
+	Resource  schema.GroupVersionResource
+	Kind      string
+	Namespace string
+	Name      string
+	State     adoptionState
+	Reason    string
+}
+
+func (a adoption) String() string {
+	if a.Namespace == "" {
+		return fmt.Sprintf("%s/%s", a.Kind, a.Name)
+	}
+	return fmt.Sprintf("%s/%s in %s", a.Kind, a.Name, a.Namespace)
+}
+
+// Adopt renders the chart and patches the helm ownership labels and annotations onto each
+// object that already exists in the cluster, so that helm install can take them over. nothing
+// is patched if any object's spec would change or it's owned by another release
+func Adopt(ctx context.Context, clients Clients, opts AdoptOpts) error {
+	objects, err := renderChart(clients, opts)
+	if err != nil {
+		return err
+	}
+
+	// the chart is rendered again to find the generated values, such as tls material and
+	// random strings. they are different in every render, so they aren't compared
+	again, err := renderChart(clients, opts)
+	if err != nil {
+		return err
+	}
+
+	adoptions, err := planAdoptions(ctx, clients, objects, again, opts)
+	if err != nil {
+		return err
+	}
+
+	refused := []adoption{}
+	for _, a := range adoptions {
+		if a.State == adoptionStateRefused {
+			refused = append(refused, a)
+		}
+	}
+	if len(refused) > 0 {
+		fmt.Fprintln(opts.Out, "The following objects can't be adopted:")
+		for _, a := range refused {
+			fmt.Fprintf(opts.Out, "    %s: %s\n", a, a.Reason)
+		}
+		return errors.Errorf("%d objects can't be adopted", len(refused))
+	}
+
+	if opts.DryRun {
+		return printAdoptionScript(opts, adoptions)
+	}
+
+	for _, a := range adoptions {
+		switch a.State {
+		case adoptionStateNew:
+			fmt.Fprintf(opts.Out, "%s will be created by helm\n", a)
+		case adoptionStateOwned:
+			fmt.Fprintf(opts.Out, "%s is already owned by the release\n", a)
+		case adoptionStateAdopt:
+			patch, err := ownershipPatch(opts)
+			if err != nil {
+				return err
+			}
+			_, err = resourceClient(clients.Dynamic, a).Patch(ctx, a.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+			if err != nil {
+				return errors.Wrapf(err, "failed to patch %s", a)
+			}
+			fmt.Fprintf(opts.Out, "%s adopted\n", a)
+		}
+	}
+
+	return nil
+}
+
+// renderChart renders the chart the same as helm install would. lookup reads the live objects
+// when clients has a config
+func renderChart(clients Clients, opts AdoptOpts) ([]*unstructured.Unstructured, error) {
+	chart, err := loader.Load(opts.ChartPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load chart")
+	}
+
+	values := chartutil.Values{}
+	for _, valuesFile := range opts.ValuesFiles {
+		fileValues, err := chartutil.ReadValuesFile(valuesFile)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", valuesFile)
+		}
+		values = chartutil.CoalesceTables(fileValues, values)
+	}
+
+	releaseOptions := chartutil.ReleaseOptions{
+		Name:      opts.ReleaseName,
+		Namespace: opts.Namespace,
+		IsInstall: true,
+	}
+	renderValues, err := chartutil.ToRenderValues(chart, values, releaseOptions, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create render values")
+	}
+
+	rendered, err := engine.RenderWithClient(chart, renderValues, clients.Config)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to render chart")
+	}
+
+	files := []string{}
+	for file := range rendered {
+		if filepath.Ext(file) == ".yaml" || filepath.Ext(file) == ".yml" {
+			files = append(files, file)
+		}
+	}
+	sort.Strings(files)
+
+	objects := []*unstructured.Unstructured{}
+	for _, file := range files {
+		manifests := releaseutil.SplitManifests(rendered[file])
+		keys := []string{}
+		for key := range manifests {
+			keys = append(keys, key)
+		}
+		sort.Sort(releaseutil.BySplitManifestsOrder(keys))
+
+		for _, key := range keys {
+			doc, err := yaml.ToJSON([]byte(manifests[key]))
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to parse %s", file)
+			}
+			obj := map[string]interface{}{}
+			if err := json.Unmarshal(doc, &obj); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse %s", file)
+			}
+			if len(obj) == 0 {
+				continue
+			}
+			objects = append(objects, &unstructured.Unstructured{Object: obj})
+		}
+	}
+
+	return objects, nil
+}
+
+// planAdoptions finds the live object for each rendered object and decides if it can be adopted.
+// again is a second render of the same chart, in the same order
+func planAdoptions(ctx context.Context, clients Clients, objects []*unstructured.Unstructured, again []*unstructured.Unstructured, opts AdoptOpts) ([]adoption, error) {
+	groupResources, err := restmapper.GetAPIGroupResources(clients.Discovery)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get api resources")
+	}
+	mapper := restmapper.NewDiscoveryRESTMapper(groupResources)
+
+	adoptions := []adoption{}
+	for i, obj := range objects {
+		gvk := obj.GroupVersionKind()
+		mapping, err := mapper.RESTMapping(gvk.GroupKind(), gvk.Version)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to find the resource for %s", gvk)
+		}
+
+		a := adoption{
+			Resource: mapping.Resource,
+			Kind:     gvk.Kind,
+			Name:     obj.GetName(),
+		}
+		if mapping.Scope.Name() == meta.RESTScopeNameNamespace {
+			a.Namespace = obj.GetNamespace()
+			if a.Namespace == "" {
+				a.Namespace = opts.Namespace
+			}
+		}
+
+		live, err := resourceClient(clients.Dynamic, a).Get(ctx, a.Name, metav1.GetOptions{})
+		if kuberneteserrors.IsNotFound(err) {
+			a.State = adoptionStateNew
+			adoptions = append(adoptions, a)
+			continue
+		} else if err != nil {
+			return nil, errors.Wrapf(err, "failed to get %s", a)
+		}
+
+		var other *unstructured.Unstructured
+		if i < len(again) && again[i].GroupVersionKind() == gvk && again[i].GetName() == obj.GetName() {
+			other = again[i]
+		}
+
+		a.State, a.Reason = adoptionStateFor(obj, other, live, opts)
+		adoptions = append(adoptions, a)
+	}
+
+	return adoptions, nil
+}
+
+// adoptionStateFor compares rendered with live. again is the same object from a second render,
+// fields that are different in it were generated by the chart and are ignored
+func adoptionStateFor(rendered *unstructured.Unstructured, again *unstructured.Unstructured, live *unstructured.Unstructured, opts AdoptOpts) (adoptionState, string) {
+	annotations := live.GetAnnotations()
+	if releaseName, ok := annotations[releaseNameAnnotation]; ok {
+		if releaseName == opts.ReleaseName && annotations[releaseNamespaceAnnotation] == opts.Namespace {
+			return adoptionStateOwned, ""
+		}
+		return adoptionStateRefused, fmt.Sprintf("owned by release %s in %s", releaseName, annotations[releaseNamespaceAnnotation])
+	}
+
+	renderedContent := comparableContent(rendered.Object)
+	againContent := renderedContent
+	if again != nil {
+		againContent = comparableContent(again.Object)
+	}
+	liveContent := comparableContent(live.Object)
+	keys := []string{}
+	for key := range renderedContent {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if path, ok := isSubset(renderedContent[key], againContent[key], liveContent[key], key); !ok {
+			return adoptionStateRefused, fmt.Sprintf("%s would change", path)
+		}
+	}
+
+	return adoptionStateAdopt, ""
+}
+
+// comparableContent is everything in an object other than its metadata and status. the
+// stringData of a Secret is compared as data, since that's how it's stored
+func comparableContent(obj map[string]interface{}) map[string]interface{} {
+	content := map[string]interface{}{}
+	for key, value := range obj {
+		switch key {
+		case "apiVersion", "kind", "metadata", "status":
+			continue
+		}
+		content[key] = value
+	}
+
+	if stringData, ok := content["stringData"].(map[string]interface{}); ok {
+		data, _ := content["data"].(map[string]interface{})
+		merged := map[string]interface{}{}
+		for key, value := range data {
+			merged[key] = value
+		}
+		for key, value := range stringData {
+			merged[key] = base64.StdEncoding.EncodeToString([]byte(fmt.Sprint(value)))
+		}
+		content["data"] = merged
+		delete(content, "stringData")
+	}
+
+	return content
+}
+
+// isSubset is true when every field in rendered has the same value in live. live objects
+// have defaults filled in by the api server, so fields that are only in live are ignored.
+// fields that have another value in again, the second render, are generated and ignored too
+func isSubset(rendered interface{}, again interface{}, live interface{}, path string) (string, bool) {
+	if !reflect.DeepEqual(rendered, again) {
+		if _, ok := rendered.(map[string]interface{}); !ok {
+			return "", true
+		}
+	}
+
+	switch r := rendered.(type) {
+	case nil:
+		return "", true
+	case map[string]interface{}:
+		l, ok := live.(map[string]interface{})
+		if !ok {
+			if len(r) == 0 && live == nil {
+				return "", true
+			}
+			return path, false
+		}
+		keys := []string{}
+		for key := range r {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		a, _ := again.(map[string]interface{})
+		for _, key := range keys {
+			if p, ok := isSubset(r[key], a[key], l[key], path+"."+key); !ok {
+				return p, false
+			}
+		}
+		return "", true
+	case []interface{}:
+		l, ok := live.([]interface{})
+		if !ok {
+			if len(r) == 0 && live == nil {
+				return "", true
+			}
+			return path, false
+		}
+		if len(r) != len(l) {
+			return path, false
+		}
+		a, _ := again.([]interface{})
+		if len(a) != len(r) {
+			a = r
+		}
+		for i := range r {
+			if p, ok := isSubset(r[i], a[i], l[i], fmt.Sprintf("%s[%d]", path, i)); !ok {
+				return p, false
+			}
+		}
+		return "", true
+	}
+
+	if isQuantityPath(path) {
+		if r, ok := toQuantity(rendered); ok {
+			if l, ok := toQuantity(live); ok {
+				if r.Cmp(l) == 0 {
+					return "", true
+				}
+				return path, false
+			}
+		}
+	}
+
+	if isNumber(rendered) && isNumber(live) {
+		if fmt.Sprint(toFloat(rendered)) == fmt.Sprint(toFloat(live)) {
+			return "", true
+		}
+		return path, false
+	}
+
+	if reflect.DeepEqual(rendered, live) {
+		return "", true
+	}
+	return path, false
+}
+
+// quantityParents are the keys of the maps that hold resource quantities, such as the
+// requests and limits of a container, the hard limits of a ResourceQuota and the limits
+// of a LimitRange
+var quantityParents = map[string]bool{
+	"requests":             true,
+	"limits":               true,
+	"hard":                 true,
+	"capacity":             true,
+	"overhead":             true,
+	"default":              true,
+	"defaultRequest":       true,
+	"max":                  true,
+	"min":                  true,
+	"maxLimitRequestRatio": true,
+}
+
+// isQuantityPath is true when the field at path is a resource quantity, which is compared by
+// its value since the api server can return it in another form, such as 500m for 0.5
+func isQuantityPath(path string) bool {
+	segments := strings.Split(path, ".")
+	if segments[len(segments)-1] == "sizeLimit" {
+		return true
+	}
+	if len(segments) < 2 {
+		return false
+	}
+	return quantityParents[segments[len(segments)-2]]
+}
+
+func toQuantity(value interface{}) (resource.Quantity, bool) {
+	s, ok := value.(string)
+	if !ok {
+		if !isNumber(value) {
+			return resource.Quantity{}, false
+		}
+		s = fmt.Sprint(value)
+	}
+
+	q, err := resource.ParseQuantity(s)
+	if err != nil {
+		return resource.Quantity{}, false
+	}
+	return q, true
+}
+
+func isNumber(value interface{}) bool {
+	switch value.(type) {
+	case int, int32, int64, float32, float64:
+		return true
+	}
+	return false
+}
+
+func toFloat(value interface{}) float64 {
+	switch v := value.(type) {
+	case int:
+		return float64(v)
+	case int32:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case float32:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+func resourceClient(client dynamic.Interface, a adoption) dynamic.ResourceInterface {
+	if a.Namespace == "" {
+		return client.Resource(a.Resource)
+	}
+	return client.Resource(a.Resource).Namespace(a.Namespace)
+}
+
+// ownershipPatch is the merge patch with the labels and annotations helm uses to decide
+// that an existing object belongs to a release
+func ownershipPatch(opts AdoptOpts) ([]byte, error) {
+	patch := map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"labels": map[string]string{
+				managedByLabel: "Helm",
+			},
+			"annotations": map[string]string{
+				releaseNameAnnotation:      opts.ReleaseName,
+				releaseNamespaceAnnotation: opts.Namespace,
+			},
+		},
+	}
+
+	b, err := json.Marshal(patch)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal patch")
+	}
+
+	return b, nil
+}
+
+// printAdoptionScript prints the kubectl commands that adopt the objects
+func printAdoptionScript(opts AdoptOpts, adoptions []adoption) error {
+	patch, err := ownershipPatch(opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(opts.Out, "#!/bin/sh")
+	fmt.Fprintln(opts.Out, "set -e")
+	for _, a := range adoptions {
+		if a.State != adoptionStateAdopt {
+			fmt.Fprintf(opts.Out, "# %s: %s\n", a, a.State)
+			continue
+		}
+
+		resource := a.Resource.Resource
+		if a.Resource.Group != "" {
+			resource = fmt.Sprintf("%s.%s.%s", a.Resource.Resource, a.Resource.Version, a.Resource.Group)
+		}
+
+		namespace := ""
+		if a.Namespace != "" {
+			namespace = fmt.Sprintf(" --namespace %s", a.Namespace)
+		}
+
+		fmt.Fprintf(opts.Out, "kubectl patch %s %s%s --type merge --patch '%s'\n", resource, a.Name, namespace, patch)
+	}
+
+	return nil
+}
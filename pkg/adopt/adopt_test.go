@@ -0,0 +1,298 @@
+package adopt
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	fakediscovery "k8s.io/client-go/discovery/fake"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	clienttesting "k8s.io/client-go/testing"
+)
+
+var (
+	configMapsResource   = schema.GroupVersionResource{Version: "v1", Resource: "configmaps"}
+	clusterRolesResource = schema.GroupVersionResource{Group: "rbac.authorization.k8s.io", Version: "v1", Resource: "clusterroles"}
+)
+
+func testChart(t *testing.T) string {
+	chartDir := t.TempDir()
+	files := map[string]string{
+		"Chart.yaml": `apiVersion: v2
+name: app
+version: 0.1.0
+`,
+		"values.yaml": `config:
+  mode: production
+`,
+		"templates/configmap.yaml": `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app-config
+data:
+  mode: {{ .Values.config.mode }}
+`,
+		"templates/clusterrole.yaml": `apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: app-reader
+rules:
+  - apiGroups: [""]
+    resources: ["pods"]
+    verbs: ["get"]
+`,
+	}
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(chartDir, name)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(chartDir, name), []byte(content), 0644))
+	}
+
+	return chartDir
+}
+
+func testClients(objects ...runtime.Object) Clients {
+	discovery := &fakediscovery.FakeDiscovery{
+		Fake: &clienttesting.Fake{
+			Resources: []*metav1.APIResourceList{
+				{
+					GroupVersion: "v1",
+					APIResources: []metav1.APIResource{
+						{Name: "configmaps", Kind: "ConfigMap", Namespaced: true},
+					},
+				},
+				{
+					GroupVersion: "rbac.authorization.k8s.io/v1",
+					APIResources: []metav1.APIResource{
+						{Name: "clusterroles", Kind: "ClusterRole", Namespaced: false},
+					},
+				},
+			},
+		},
+	}
+
+	return Clients{
+		Discovery: discovery,
+		Dynamic:   dynamicfake.NewSimpleDynamicClient(runtime.NewScheme(), objects...),
+	}
+}
+
+func liveConfigMap(mode string, annotations map[string]interface{}) *unstructured.Unstructured {
+	metadata := map[string]interface{}{
+		"name":      "app-config",
+		"namespace": "app",
+		"labels": map[string]interface{}{
+			"kots.io/app-slug": "app",
+		},
+	}
+	if annotations != nil {
+		metadata["annotations"] = annotations
+	}
+
+	return &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "v1",
+		"kind":       "ConfigMap",
+		"metadata":   metadata,
+		"data": map[string]interface{}{
+			"mode": mode,
+		},
+	}}
+}
+
+func Test_Adopt(t *testing.T) {
+	req := require.New(t)
+
+	clients := testClients(liveConfigMap("production", nil))
+	out := bytes.Buffer{}
+	opts := AdoptOpts{
+		ChartPath:   testChart(t),
+		ReleaseName: "app",
+		Namespace:   "app",
+		Out:         &out,
+	}
+
+	req.NoError(Adopt(context.Background(), clients, opts))
+	assert.Equal(t, `ClusterRole/app-reader will be created by helm
+ConfigMap/app-config in app adopted
+`, out.String())
+
+	adopted, err := clients.Dynamic.Resource(configMapsResource).Namespace("app").Get(context.Background(), "app-config", metav1.GetOptions{})
+	req.NoError(err)
+	assert.Equal(t, map[string]string{
+		"app.kubernetes.io/managed-by": "Helm",
+		"kots.io/app-slug":             "app",
+	}, adopted.GetLabels())
+	assert.Equal(t, map[string]string{
+		"meta.helm.sh/release-name":      "app",
+		"meta.helm.sh/release-namespace": "app",
+	}, adopted.GetAnnotations())
+}
+
+func Test_AdoptDryRun(t *testing.T) {
+	req := require.New(t)
+
+	clusterRole := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "rbac.authorization.k8s.io/v1",
+		"kind":       "ClusterRole",
+		"metadata": map[string]interface{}{
+			"name": "app-reader",
+		},
+		"rules": []interface{}{
+			map[string]interface{}{
+				"apiGroups": []interface{}{""},
+				"resources": []interface{}{"pods"},
+				"verbs":     []interface{}{"get"},
+			},
+		},
+	}}
+	clients := testClients(liveConfigMap("production", nil), clusterRole)
+	out := bytes.Buffer{}
+	opts := AdoptOpts{
+		ChartPath:   testChart(t),
+		ReleaseName: "app",
+		Namespace:   "app",
+		DryRun:      true,
+		Out:         &out,
+	}
+
+	req.NoError(Adopt(context.Background(), clients, opts))
+	assert.Equal(t, `#!/bin/sh
+set -e
+kubectl patch clusterroles.v1.rbac.authorization.k8s.io app-reader --type merge --patch '{"metadata":{"annotations":{"meta.helm.sh/release-name":"app","meta.helm.sh/release-namespace":"app"},"labels":{"app.kubernetes.io/managed-by":"Helm"}}}'
+kubectl patch configmaps app-config --namespace app --type merge --patch '{"metadata":{"annotations":{"meta.helm.sh/release-name":"app","meta.helm.sh/release-namespace":"app"},"labels":{"app.kubernetes.io/managed-by":"Helm"}}}'
+`, out.String())
+
+	// nothing is patched in a dry run
+	live, err := clients.Dynamic.Resource(clusterRolesResource).Get(context.Background(), "app-reader", metav1.GetOptions{})
+	req.NoError(err)
+	assert.Empty(t, live.GetAnnotations())
+}
+
+func Test_AdoptRefused(t *testing.T) {
+	tests := []struct {
+		name      string
+		configMap *unstructured.Unstructured
+		expect    string
+	}{
+		{
+			name:      "spec would change",
+			configMap: liveConfigMap("development", nil),
+			expect: `The following objects can't be adopted:
+    ConfigMap/app-config in app: data.mode would change
+`,
+		},
+		{
+			name: "owned by another release",
+			configMap: liveConfigMap("production", map[string]interface{}{
+				"meta.helm.sh/release-name":      "other",
+				"meta.helm.sh/release-namespace": "app",
+			}),
+			expect: `The following objects can't be adopted:
+    ConfigMap/app-config in app: owned by release other in app
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+
+			clients := testClients(tt.configMap)
+			out := bytes.Buffer{}
+			opts := AdoptOpts{
+				ChartPath:   testChart(t),
+				ReleaseName: "app",
+				Namespace:   "app",
+				Out:         &out,
+			}
+
+			req.Error(Adopt(context.Background(), clients, opts))
+			assert.Equal(t, tt.expect, out.String())
+
+			live, err := clients.Dynamic.Resource(configMapsResource).Namespace("app").Get(context.Background(), "app-config", metav1.GetOptions{})
+			req.NoError(err)
+			assert.NotContains(t, live.GetLabels(), "app.kubernetes.io/managed-by")
+		})
+	}
+}
+
+func Test_adoptionStateFor(t *testing.T) {
+	secret := func(token string, password string) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "v1",
+			"kind":       "Secret",
+			"metadata":   map[string]interface{}{"name": "app"},
+			"stringData": map[string]interface{}{"token": token, "password": password},
+		}}
+	}
+	deployment := func(cpu interface{}, memory interface{}) *unstructured.Unstructured {
+		return &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "apps/v1",
+			"kind":       "Deployment",
+			"metadata":   map[string]interface{}{"name": "app"},
+			"spec": map[string]interface{}{
+				"containers": []interface{}{
+					map[string]interface{}{
+						"name": "app",
+						"resources": map[string]interface{}{
+							"requests": map[string]interface{}{"cpu": cpu, "memory": memory},
+						},
+					},
+				},
+			},
+		}}
+	}
+
+	tests := []struct {
+		name     string
+		rendered *unstructured.Unstructured
+		again    *unstructured.Unstructured
+		live     *unstructured.Unstructured
+		state    adoptionState
+		reason   string
+	}{
+		{
+			name:     "generated values are ignored",
+			rendered: secret("abc", "secret"),
+			again:    secret("def", "secret"),
+			live:     secret("xyz", "secret"),
+			state:    adoptionStateAdopt,
+		},
+		{
+			name:     "values that aren't generated are compared",
+			rendered: secret("abc", "secret"),
+			again:    secret("def", "secret"),
+			live:     secret("xyz", "other"),
+			state:    adoptionStateRefused,
+			reason:   "data.password would change",
+		},
+		{
+			name:     "equivalent quantities",
+			rendered: deployment("0.5", 1),
+			again:    deployment("0.5", 1),
+			live:     deployment("500m", "1"),
+			state:    adoptionStateAdopt,
+		},
+		{
+			name:     "different quantities",
+			rendered: deployment("0.5", "1Gi"),
+			again:    deployment("0.5", "1Gi"),
+			live:     deployment("500m", "1G"),
+			state:    adoptionStateRefused,
+			reason:   "spec.containers[0].resources.requests.memory would change",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state, reason := adoptionStateFor(tt.rendered, tt.again, tt.live, AdoptOpts{ReleaseName: "app", Namespace: "app"})
+			assert.Equal(t, tt.state, state)
+			assert.Equal(t, tt.reason, reason)
+		})
+	}
+}
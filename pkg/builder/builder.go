@@ -5,6 +5,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 
 	"github.com/pkg/errors"
 	"github.com/plus3it/gorecurcopy"
@@ -15,8 +16,39 @@ import (
 	"helm.sh/helm/v3/pkg/getter"
 )
 
+// BuildOpts are the options used when building a helm chart
+type BuildOpts struct {
+	// Strict fails the build when any kots template function remains after conversion
+	Strict bool
+	// OnUnconverted is one of comment, fail or keep
+	OnUnconverted string
+	// CacheDir is where converted files are cached between runs, no cache is used when empty
+	CacheDir string
+	// Concurrency is the number of files to convert at the same time
+	Concurrency int
+	// PrefixNames prefixes resource names with the release fullname, so the chart can be
+	// installed more than once in a cluster
+	PrefixNames bool
+	// AppNamespaces are the namespaces the app was installed to with kots. when empty, it's
+	// the namespace most manifests share, and nothing is rewritten when there isn't one
+	AppNamespaces []string
+	// ValuesNaming is one of index or camelCase
+	ValuesNaming string
+	// ValuesLayout is one of grouped or flat
+	ValuesLayout string
+	// ValuesMapFile pins config items to values paths, not used when empty
+	ValuesMapFile string
+	// ValuesLockFile is the values-map.lock that keeps values paths stable between builds.
+	// it's read if it exists and written after the conversion, not used when empty
+	ValuesLockFile string
+}
+
 // Build will create a helm chart from the given input dir
-func Build(inputDir string, name string, version string) error {
+func Build(inputDir string, name string, version string, opts BuildOpts) error {
+	unconvertedPolicy, err := getUnconvertedPolicy(opts)
+	if err != nil {
+		return err
+	}
 
 	// create a temp dir with a copy of the workspace so we can edit
 	workspace, err := ioutil.TempDir("", "helm")
@@ -44,25 +76,32 @@ func Build(inputDir string, name string, version string) error {
 
 	// TODO: copy depenencies and existing helm charts
 
-	if err := createValuesYAML(workspace); err != nil {
+	index, err := indexWorkspace(workspace)
+	if err != nil {
 		return err
 	}
-
-	if err := createChartYAML(workspace, name, version); err != nil {
+	conversionOpts, err := getConversionOpts(index, name, opts)
+	if err != nil {
 		return err
 	}
 
-	remainingKOTSTemplateFunctionsMap, err := replaceKOTSTemplatesWithHelmTemplates(workspace)
+	remainingKOTSTemplateFunctionsMap, err := convertWorkspace(workspace, name, version, index, conversionOpts, opts)
 	if err != nil {
 		return err
 	}
 
-	// if err := replaceStaticImagesWithTemplates(build, workspace); err != nil {
-	// 	return err
-	// }
+	printConversionReport(remainingKOTSTemplateFunctionsMap)
 
-	if err := removeKOTSManifests(workspace); err != nil {
-		return err
+	if len(remainingKOTSTemplateFunctionsMap) > 0 && unconvertedPolicy == UnconvertedPolicyFail {
+		return errors.Errorf("%d files have template functions that could not be converted", len(remainingKOTSTemplateFunctionsMap))
+	}
+
+	if err := validateHelmChart(workspace, inputDir, opts.Strict); err != nil {
+		// templates that were kept unconverted are expected to fail validation,
+		// the user has already been told about them
+		if unconvertedPolicy != UnconvertedPolicyKeep || len(remainingKOTSTemplateFunctionsMap) == 0 {
+			return err
+		}
 	}
 
 	archiveFile, err := packageHelmChart(workspace)
@@ -72,13 +111,6 @@ func Build(inputDir string, name string, version string) error {
 
 	wasSuccessful = len(remainingKOTSTemplateFunctionsMap) == 0
 
-	if !wasSuccessful {
-		fmt.Println("The following files have template functions that could not be converted:")
-		for path, count := range remainingKOTSTemplateFunctionsMap {
-			fmt.Printf("%s: %d\n", path, count)
-		}
-	}
-
 	fmt.Printf("chart is at %s\n", archiveFile)
 
 	// if err := build.publishHelmChart(archiveFile, r); err != nil {
@@ -89,6 +121,220 @@ func Build(inputDir string, name string, version string) error {
 	return nil
 }
 
+func getUnconvertedPolicy(opts BuildOpts) (UnconvertedPolicy, error) {
+	if opts.Strict {
+		return UnconvertedPolicyFail, nil
+	}
+
+	return parseUnconvertedPolicy(opts.OnUnconverted)
+}
+
+// convertWorkspace creates the chart files in workspace and converts the kots manifests
+// that have been copied to its templates dir. the number of template functions that could
+// not be converted in each file is returned
+func convertWorkspace(workspace string, name string, version string, index *workspaceIndex, conversionOpts conversionOpts, opts BuildOpts) (map[string]int, error) {
+	if err := createValuesFiles(workspace, index, conversionOpts.Values); err != nil {
+		return nil, err
+	}
+
+	if err := createEmbeddedClusterValuesYAML(workspace); err != nil {
+		return nil, err
+	}
+
+	if err := createChartYAML(workspace, name, version); err != nil {
+		return nil, err
+	}
+
+	if err := moveCRDs(workspace, conversionOpts); err != nil {
+		return nil, err
+	}
+
+	remainingKOTSTemplateFunctionsMap, err := replaceKOTSTemplatesWithHelmTemplates(workspace, index, conversionOpts)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := createGeneratedTemplates(workspace, name, index, conversionOpts.Values); err != nil {
+		return nil, err
+	}
+
+	// if err := replaceStaticImagesWithTemplates(build, workspace); err != nil {
+	// 	return err
+	// }
+
+	if err := removeKOTSManifests(workspace); err != nil {
+		return nil, err
+	}
+
+	if opts.ValuesLockFile != "" {
+		kotsConfig, err := getKOTSConfig(index)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to get config")
+		}
+		if kotsConfig != nil {
+			if err := writeValuesLock(opts.ValuesLockFile, kotsConfig, conversionOpts.Values); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return remainingKOTSTemplateFunctionsMap, nil
+}
+
+// createValuesFiles creates values.yaml and values.schema.json from the kots config, the
+// kots application and the kurl installer
+func createValuesFiles(workspace string, index *workspaceIndex, valuesOpts ValuesOpts) error {
+	if err := createValuesYAML(workspace, index, valuesOpts); err != nil {
+		return err
+	}
+
+	if err := createValuesSchema(workspace, index, valuesOpts); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// generatedTemplates are the templates that kots2helm adds to the chart
+var generatedTemplates = []string{
+	"_helpers.tpl",
+	tlsSecretTemplate,
+	randomSecretTemplate,
+	passwordSecretTemplate,
+	namespacesTemplate,
+}
+
+// createGeneratedTemplates creates _helpers.tpl, the tls, random value and password Secrets
+// and the Namespaces. they are created after the manifests are converted, since the tls
+// Secret is made from the certificates the converted manifests use
+func createGeneratedTemplates(workspace string, name string, index *workspaceIndex, valuesOpts ValuesOpts) error {
+	if err := createHelpersTPL(workspace, name, index, valuesOpts); err != nil {
+		return err
+	}
+
+	if err := createTLSSecretTemplate(workspace, name); err != nil {
+		return err
+	}
+
+	if err := createRandomSecretTemplate(workspace, name, index, valuesOpts); err != nil {
+		return err
+	}
+
+	if err := createPasswordSecretTemplate(workspace, name, index, valuesOpts); err != nil {
+		return err
+	}
+
+	if err := createNamespacesTemplate(workspace, name, index); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// removeGeneratedTemplates removes the templates created by createGeneratedTemplates, so they
+// can be created again
+func removeGeneratedTemplates(workspace string) error {
+	for _, template := range generatedTemplates {
+		if err := os.RemoveAll(filepath.Join(workspace, "templates", template)); err != nil {
+			return errors.Wrapf(err, "failed to remove %s", template)
+		}
+	}
+
+	return nil
+}
+
+func getConversionOpts(index *workspaceIndex, name string, opts BuildOpts) (conversionOpts, error) {
+	unconvertedPolicy, err := getUnconvertedPolicy(opts)
+	if err != nil {
+		return conversionOpts{}, err
+	}
+
+	valuesOpts, err := GetValuesOpts(opts.ValuesNaming, opts.ValuesLayout, opts.ValuesMapFile, opts.ValuesLockFile)
+	if err != nil {
+		return conversionOpts{}, err
+	}
+
+	c := conversionOpts{
+		UnconvertedPolicy: unconvertedPolicy,
+		ChartName:         name,
+		Concurrency:       opts.Concurrency,
+		Values:            valuesOpts,
+	}
+
+	kotsConfig, err := getKOTSConfig(index)
+	if err != nil {
+		return conversionOpts{}, errors.Wrap(err, "failed to get config")
+	}
+	if kotsConfig != nil {
+		if err := validateValuesKeys(kotsConfig, c.Values); err != nil {
+			return conversionOpts{}, err
+		}
+
+		derived, err := getDerivedConfigItemNames(kotsConfig, name, c.Values)
+		if err != nil {
+			return conversionOpts{}, err
+		}
+		conditional, err := getConditionalConfigItemNames(kotsConfig, name, c.Values)
+		if err != nil {
+			return conversionOpts{}, err
+		}
+		if len(derived) > 0 {
+			c.Values.Derived = derived
+		}
+		if len(conditional) > 0 {
+			c.Values.Conditional = conditional
+		}
+		if err := validateConfigItemDependencies(kotsConfig, c.Values); err != nil {
+			return conversionOpts{}, err
+		}
+
+		validations, err := getConfigItemValidations(index)
+		if err != nil {
+			return conversionOpts{}, errors.Wrap(err, "failed to get config validations")
+		}
+		if len(validations) > 0 {
+			c.Values.Validations = validations
+		}
+	}
+
+	if opts.PrefixNames {
+		c.PrefixedNames = getPrefixedNames(index)
+		c.PrefixedHosts = getPrefixedHosts(c.PrefixedNames)
+	}
+
+	namespaces, err := getNamespaceOpts(index, opts.AppNamespaces)
+	if err != nil {
+		return conversionOpts{}, errors.Wrap(err, "failed to get namespaces")
+	}
+	c.Namespaces = namespaces
+
+	if opts.CacheDir != "" {
+		cache, err := newConversionCache(opts.CacheDir, kotsConfig, c)
+		if err != nil {
+			return conversionOpts{}, errors.Wrap(err, "failed to create cache")
+		}
+		c.Cache = cache
+	}
+
+	return c, nil
+}
+
+func printConversionReport(remainingKOTSTemplateFunctionsMap map[string]int) {
+	if len(remainingKOTSTemplateFunctionsMap) == 0 {
+		return
+	}
+
+	fmt.Println("The following files have template functions that could not be converted:")
+	paths := []string{}
+	for path := range remainingKOTSTemplateFunctionsMap {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Printf("%s: %d\n", path, remainingKOTSTemplateFunctionsMap[path])
+	}
+}
+
 // removeKOTSManifests will remove all kots manifests from the root of workspace
 // this should be done last as other methods in build will rely on these to exist
 func removeKOTSManifests(workspace string) error {
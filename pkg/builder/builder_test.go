@@ -0,0 +1,204 @@
+package builder
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+)
+
+const testRenderConfig = `apiVersion: kots.io/v1beta1
+kind: Config
+metadata:
+  name: config
+spec:
+  groups:
+    - name: app
+      items:
+        - name: hostname
+          title: Hostname
+          type: text
+          required: true
+        - name: port
+          title: Port
+          type: text
+          default: "8080"
+          validation:
+            regex:
+              pattern: ^[0-9]+$
+              message: must be a number
+        - name: url
+          type: text
+          value: 'https://repl{{ ConfigOption "hostname" }}:repl{{ ConfigOption "port" }}'
+        - name: use_smtp
+          type: bool
+          default: "0"
+        - name: smtp_host
+          type: text
+          default: mail.example.com
+          when: 'repl{{ ConfigOptionEquals "use_smtp" "1" }}'
+        - name: api_token
+          type: password
+          value: 'repl{{ RandomString 24 "[a-f0-9]" }}'
+`
+
+const testRenderManifests = `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  hostname: repl{{ ConfigOption "hostname" }}
+  port: 'repl{{ ConfigOption "port" }}'
+  url: repl{{ ConfigOption "url" }}
+  smtp_host: repl{{ ConfigOption "smtp_host" }}
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: web-tls
+type: kubernetes.io/tls
+data:
+  ca.crt: repl{{ TLSCACert "ca" | b64enc }}
+  tls.crt: repl{{ TLSCertFromCA "ca" "web" "web.example.com" | b64enc }}
+  tls.key: repl{{ TLSKeyFromCA "ca" "web" "web.example.com" | b64enc }}
+stringData:
+  token: repl{{ ConfigOption "api_token" }}
+`
+
+// renderTestChart renders the chart in workspace with values the same as helm install does,
+// so fail and required guards stop the render
+func renderTestChart(t *testing.T, workspace string, values map[string]interface{}) (map[string]string, error) {
+	chart, err := loader.Load(workspace)
+	require.NoError(t, err)
+
+	options := chartutil.ReleaseOptions{
+		Name:      "rel",
+		Namespace: "default",
+		Revision:  1,
+		IsInstall: true,
+	}
+	renderValues, err := chartutil.ToRenderValues(chart, values, options, chartutil.DefaultCapabilities)
+	require.NoError(t, err)
+
+	return engine.Render(chart, renderValues)
+}
+
+func Test_convertWorkspace_render(t *testing.T) {
+	req := require.New(t)
+
+	workspace := t.TempDir()
+	req.NoError(os.MkdirAll(filepath.Join(workspace, "templates"), 0755))
+	req.NoError(os.WriteFile(filepath.Join(workspace, "templates", "config.yaml"), []byte(testRenderConfig), 0644))
+	req.NoError(os.WriteFile(filepath.Join(workspace, "templates", "app.yaml"), []byte(testRenderManifests), 0644))
+
+	opts := BuildOpts{Concurrency: 1}
+	index, err := indexWorkspace(workspace)
+	req.NoError(err)
+	conversionOpts, err := getConversionOpts(index, "app", opts)
+	req.NoError(err)
+
+	remaining, err := convertWorkspace(workspace, "app", "0.0.1", index, conversionOpts, opts)
+	req.NoError(err)
+	req.Empty(remaining)
+
+	render := func(values map[string]interface{}) (map[string]interface{}, map[string]interface{}, error) {
+		rendered, err := renderTestChart(t, workspace, values)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		docs := yamlDocumentSeparatorRegex.Split(rendered["app/templates/app.yaml"], -1)
+		req.Len(docs, 2)
+		configMap := map[string]interface{}{}
+		req.NoError(yaml.Unmarshal([]byte(docs[0]), &configMap))
+		secret := map[string]interface{}{}
+		req.NoError(yaml.Unmarshal([]byte(docs[1]), &secret))
+
+		return configMap, secret, nil
+	}
+
+	t.Run("required", func(t *testing.T) {
+		_, _, err := render(map[string]interface{}{})
+		req.Error(err)
+		assert.Contains(t, err.Error(), "Hostname is required")
+	})
+
+	t.Run("validated", func(t *testing.T) {
+		_, _, err := render(map[string]interface{}{
+			"app": map[string]interface{}{"hostname": "example.com", "port": "http"},
+		})
+		req.Error(err)
+		assert.Contains(t, err.Error(), "Port: must be a number")
+	})
+
+	t.Run("derived and hidden", func(t *testing.T) {
+		configMap, _, err := render(map[string]interface{}{
+			"app": map[string]interface{}{"hostname": "example.com"},
+		})
+		req.NoError(err)
+		assert.Equal(t, map[interface{}]interface{}{
+			"hostname":  "example.com",
+			"port":      "8080",
+			"url":       "https://example.com:8080",
+			"smtp_host": "",
+		}, configMap["data"])
+
+		configMap, _, err = render(map[string]interface{}{
+			"app": map[string]interface{}{"hostname": "example.com", "port": "8443", "use_smtp": true, "url": "https://app.example.com"},
+		})
+		req.NoError(err)
+		assert.Equal(t, map[interface{}]interface{}{
+			"hostname":  "example.com",
+			"port":      "8443",
+			"url":       "https://app.example.com",
+			"smtp_host": "mail.example.com",
+		}, configMap["data"])
+	})
+
+	t.Run("tls", func(t *testing.T) {
+		_, secret, err := render(map[string]interface{}{
+			"app": map[string]interface{}{"hostname": "example.com"},
+		})
+		req.NoError(err)
+
+		data := map[string][]byte{}
+		for key, value := range secret["data"].(map[interface{}]interface{}) {
+			decoded, err := base64.StdEncoding.DecodeString(value.(string))
+			req.NoError(err)
+			data[key.(string)] = decoded
+		}
+
+		// the key is the one for the cert
+		_, err = tls.X509KeyPair(data["tls.crt"], data["tls.key"])
+		req.NoError(err)
+
+		// and the cert is signed by the ca
+		roots := x509.NewCertPool()
+		req.True(roots.AppendCertsFromPEM(data["ca.crt"]))
+		block, _ := pem.Decode(data["tls.crt"])
+		req.NotNil(block)
+		cert, err := x509.ParseCertificate(block.Bytes)
+		req.NoError(err)
+		assert.Equal(t, "web.example.com", cert.Subject.CommonName)
+		_, err = cert.Verify(x509.VerifyOptions{Roots: roots})
+		assert.NoError(t, err)
+	})
+
+	t.Run("random charset", func(t *testing.T) {
+		_, secret, err := render(map[string]interface{}{
+			"app": map[string]interface{}{"hostname": "example.com"},
+		})
+		req.NoError(err)
+		assert.Regexp(t, `^[a-f0-9]{24}$`, secret["stringData"].(map[interface{}]interface{})["token"])
+	})
+}
@@ -0,0 +1,124 @@
+package builder
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/replicatedhq/kots2helm/pkg/version"
+)
+
+// conversionCache stores converted files on disk so that files that haven't
+// changed are not converted again on the next run. entries are keyed by the
+// source content, the config spec, the conversion options and the converter version
+type conversionCache struct {
+	dir         string
+	fingerprint string
+}
+
+type cachedConversion struct {
+	Content   []byte `json:"content"`
+	Remaining int    `json:"remaining"`
+}
+
+func newConversionCache(dir string, kotsConfig *kotsv1beta1.Config, opts conversionOpts) (*conversionCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrap(err, "failed to create cache dir")
+	}
+
+	h := sha256.New()
+
+	h.Write([]byte(version.Version()))
+	h.Write([]byte(version.GitSHA()))
+
+	if kotsConfig != nil {
+		spec, err := json.Marshal(kotsConfig.Spec)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to marshal config spec")
+		}
+		h.Write(spec)
+	}
+
+	marshalledOpts, err := json.Marshal(opts)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal conversion opts")
+	}
+	h.Write(marshalledOpts)
+
+	return &conversionCache{
+		dir:         dir,
+		fingerprint: hex.EncodeToString(h.Sum(nil)),
+	}, nil
+}
+
+// key returns the cache key for a file, the path is included because it
+// appears in converted content
+func (c *conversionCache) key(path string, content []byte) string {
+	h := sha256.New()
+	h.Write([]byte(c.fingerprint))
+	h.Write([]byte(path))
+	h.Write([]byte{0})
+	h.Write(content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func (c *conversionCache) filename(key string) string {
+	return filepath.Join(c.dir, key[:2], key+".json")
+}
+
+// get returns the cached conversion for key, or nil if there isn't one
+func (c *conversionCache) get(key string) (*cachedConversion, error) {
+	b, err := ioutil.ReadFile(c.filename(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "failed to read cache entry")
+	}
+
+	conversion := cachedConversion{}
+	if err := json.Unmarshal(b, &conversion); err != nil {
+		// a corrupt entry is treated as a miss, it will be overwritten
+		return nil, nil
+	}
+
+	return &conversion, nil
+}
+
+func (c *conversionCache) put(key string, conversion cachedConversion) error {
+	b, err := json.Marshal(conversion)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal cache entry")
+	}
+
+	filename := c.filename(key)
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return errors.Wrap(err, "failed to create cache dir")
+	}
+
+	// write to a temp file first so a partial entry is never read
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), ".entry")
+	if err != nil {
+		return errors.Wrap(err, "failed to create cache entry")
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return errors.Wrap(err, "failed to write cache entry")
+	}
+	if err := tmp.Close(); err != nil {
+		return errors.Wrap(err, "failed to close cache entry")
+	}
+
+	if err := os.Rename(tmp.Name(), filename); err != nil {
+		return errors.Wrap(err, "failed to save cache entry")
+	}
+
+	return nil
+}
@@ -0,0 +1,56 @@
+package builder
+
+import (
+	"testing"
+
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_conversionCache(t *testing.T) {
+	req := require.New(t)
+
+	dir := t.TempDir()
+	kotsConfig := &kotsv1beta1.Config{
+		Spec: kotsv1beta1.ConfigSpec{
+			Groups: []kotsv1beta1.ConfigGroup{
+				{
+					Name: "group1",
+					Items: []kotsv1beta1.ConfigItem{
+						{
+							Name: "foo",
+							Type: "text",
+						},
+					},
+				},
+			},
+		},
+	}
+
+	cache, err := newConversionCache(dir, kotsConfig, conversionOpts{UnconvertedPolicy: UnconvertedPolicyKeep})
+	req.NoError(err)
+
+	key := cache.key("templates/a.yaml", []byte(`name: repl{{ ConfigOption "foo" }}`))
+
+	cached, err := cache.get(key)
+	req.NoError(err)
+	assert.Nil(t, cached)
+
+	req.NoError(cache.put(key, cachedConversion{Content: []byte(`name: {{ .Values.group1.foo }}`)}))
+
+	cached, err = cache.get(key)
+	req.NoError(err)
+	req.NotNil(cached)
+	assert.Equal(t, `name: {{ .Values.group1.foo }}`, string(cached.Content))
+
+	// a different config spec or conversion option must not reuse the entry
+	kotsConfig.Spec.Groups[0].Name = "group2"
+	changedConfig, err := newConversionCache(dir, kotsConfig, conversionOpts{UnconvertedPolicy: UnconvertedPolicyKeep})
+	req.NoError(err)
+	assert.NotEqual(t, key, changedConfig.key("templates/a.yaml", []byte(`name: repl{{ ConfigOption "foo" }}`)))
+
+	changedOpts, err := newConversionCache(dir, kotsConfig, conversionOpts{UnconvertedPolicy: UnconvertedPolicyComment})
+	req.NoError(err)
+	assert.NotEqual(t, changedConfig.key("templates/a.yaml", nil), changedOpts.key("templates/a.yaml", nil))
+}
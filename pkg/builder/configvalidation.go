@@ -0,0 +1,99 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/pkg/errors"
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"gopkg.in/yaml.v3"
+)
+
+// ConfigItemValidation is the regex validation of a config item. the kotskinds Config
+// doesn't have the field yet, so it's read from the yaml
+type ConfigItemValidation struct {
+	Pattern string `json:"pattern"`
+	Message string `json:"message"`
+}
+
+// getConfigItemValidations returns the regex validations in the kots config, by item name
+func getConfigItemValidations(index *workspaceIndex) (map[string]ConfigItemValidation, error) {
+	docs := index.documents["kots.io/v1beta1/Config"]
+	if len(docs) == 0 {
+		return nil, nil
+	}
+
+	config := struct {
+		Spec struct {
+			Groups []struct {
+				Items []struct {
+					Name       string `yaml:"name"`
+					Validation struct {
+						Regex struct {
+							Pattern string `yaml:"pattern"`
+							Message string `yaml:"message"`
+						} `yaml:"regex"`
+					} `yaml:"validation"`
+				} `yaml:"items"`
+			} `yaml:"groups"`
+		} `yaml:"spec"`
+	}{}
+	if err := yaml.Unmarshal(docs[0].Content, &config); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal %s", docs[0].Path)
+	}
+
+	validations := map[string]ConfigItemValidation{}
+	for _, group := range config.Spec.Groups {
+		for _, item := range group.Items {
+			regex := item.Validation.Regex
+			if regex.Pattern == "" {
+				continue
+			}
+			if _, err := regexp.Compile(regex.Pattern); err != nil {
+				return nil, errors.Wrapf(err, "invalid validation pattern for %s", item.Name)
+			}
+			validations[item.Name] = ConfigItemValidation{
+				Pattern: regex.Pattern,
+				Message: regex.Message,
+			}
+		}
+	}
+
+	return validations, nil
+}
+
+// guardConfigItemValue wraps the reference to a config item's value in the checks kots makes
+// before it deploys. a regex validated item goes through the validate template, which fails
+// with the validation message, and a required item fails when it's empty, unless it's a
+// password with an existingSecret. bools always have a value, so they aren't checked
+func guardConfigItemValue(chartName string, item kotsv1beta1.ConfigItem, valuesOpts ValuesOpts, reference string) string {
+	if item.Type == "bool" {
+		return reference
+	}
+
+	if validation, ok := valuesOpts.Validations[item.Name]; ok {
+		message := validation.Message
+		if message == "" {
+			message = fmt.Sprintf("must match %s", validation.Pattern)
+		}
+		reference = fmt.Sprintf(`include "%s.validate" (list %q %s %q %q)`, chartName, configItemTitle(item), operand(reference), validation.Pattern, message)
+	}
+
+	if item.Required && isPasswordConfigItem(item) {
+		// a password isn't required when it's read from an existingSecret
+		reference = fmt.Sprintf(`include "%s.passwords.required" (list $ %q %q %s)`, chartName, valuesOpts.key(item.Name), fmt.Sprintf("%s is required", configItemTitle(item)), operand(reference))
+	} else if item.Required {
+		reference = fmt.Sprintf(`required %q %s`, fmt.Sprintf("%s is required", configItemTitle(item)), operand(reference))
+	}
+
+	return reference
+}
+
+// configItemTitle returns the title of a config item for messages, or its name without one
+func configItemTitle(item kotsv1beta1.ConfigItem) string {
+	if item.Title != "" {
+		return item.Title
+	}
+
+	return item.Name
+}
@@ -0,0 +1,108 @@
+package builder
+
+import (
+	"testing"
+
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/replicatedhq/kots/kotskinds/multitype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_getConfigItemValidations(t *testing.T) {
+	index := &workspaceIndex{
+		documents: map[string][]*indexedDocument{
+			"kots.io/v1beta1/Config": {
+				{
+					Path: "config.yaml",
+					Content: []byte(`apiVersion: kots.io/v1beta1
+kind: Config
+metadata:
+  name: config
+spec:
+  groups:
+    - name: app
+      items:
+        - name: hostname
+          type: text
+        - name: port
+          type: text
+          validation:
+            regex:
+              pattern: ^[0-9]+$
+              message: must be a number
+`),
+				},
+			},
+		},
+	}
+
+	validations, err := getConfigItemValidations(index)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]ConfigItemValidation{
+		"port": {Pattern: "^[0-9]+$", Message: "must be a number"},
+	}, validations)
+
+	index.documents["kots.io/v1beta1/Config"][0].Content = []byte(`spec:
+  groups:
+    - name: app
+      items:
+        - name: port
+          validation:
+            regex:
+              pattern: "[0-9"
+`)
+	_, err = getConfigItemValidations(index)
+	assert.Error(t, err)
+}
+
+func Test_guardConfigItemValue(t *testing.T) {
+	kotsConfig := &kotsv1beta1.Config{
+		Spec: kotsv1beta1.ConfigSpec{
+			Groups: []kotsv1beta1.ConfigGroup{
+				{
+					Name: "app",
+					Items: []kotsv1beta1.ConfigItem{
+						{Name: "hostname", Title: "Hostname", Type: "text", Required: true},
+						{Name: "port", Type: "text", Default: multitype.FromString("8080")},
+						{Name: "email", Type: "text", Required: true},
+						{Name: "enabled", Type: "bool", Required: true},
+						{Name: "use_smtp", Type: "bool"},
+						{Name: "smtp_host", Title: "SMTP host", Type: "text", Required: true, When: `repl{{ ConfigOptionEquals "use_smtp" "1" }}`},
+					},
+				},
+			},
+		},
+	}
+	valuesOpts := ValuesOpts{
+		Conditional: map[string]bool{"smtp_host": true},
+		Validations: map[string]ConfigItemValidation{
+			"port":  {Pattern: "^[0-9]+$", Message: "must be a number"},
+			"email": {Pattern: ".+@.+"},
+		},
+	}
+
+	tests := []struct {
+		item   string
+		expect string
+	}{
+		{item: "hostname", expect: `required "Hostname is required" (dig "app" "hostname" "" .Values.AsMap)`},
+		{item: "port", expect: `include "app.validate" (list "port" (dig "app" "port" "8080" .Values.AsMap) "^[0-9]+$" "must be a number")`},
+		{item: "email", expect: `required "email is required" (include "app.validate" (list "email" (dig "app" "email" "" .Values.AsMap) ".+@.+" "must match .+@.+"))`},
+		{item: "enabled", expect: `dig "app" "enabled" false .Values.AsMap`},
+		// conditional items are guarded in their named template
+		{item: "smtp_host", expect: `include "app.config.smtp_host" $`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.item, func(t *testing.T) {
+			reference, err := getValueReferenceForConfigItem(tt.item, kotsConfig, "app", valuesOpts)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, reference)
+		})
+	}
+
+	conditionalItems, err := getConditionalConfigItems(kotsConfig, "app", valuesOpts)
+	require.NoError(t, err)
+	require.Len(t, conditionalItems, 1)
+	assert.Equal(t, `(required "SMTP host is required" (dig "app" "smtp_host" "" .Values.AsMap))`, conditionalItems[0].ValuesReference)
+}
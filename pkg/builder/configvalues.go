@@ -0,0 +1,265 @@
+package builder
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"gopkg.in/yaml.v3"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// configFilenamesKey is the key in the helm values that holds the filenames of file items,
+// by item name. the value of a file item is the base64 encoded content, the same as
+// ConfigOption returns in kots
+const configFilenamesKey = "configFilenames"
+
+// ConfigValuesToHelmValues converts a kots ConfigValues to helm values for the chart built
+// from the kots config. only items with a value are included, so the rest use the defaults
+// in the chart's values.yaml. values that aren't an item in the config, and passwords that
+// only have an encrypted value, are returned as warnings
+func ConfigValuesToHelmValues(configContent []byte, configValuesContent []byte, valuesOpts ValuesOpts) ([]byte, []string, error) {
+	kotsConfig, err := decodeKOTSConfig(configContent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(configValuesContent, nil, nil)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to decode config values")
+	}
+	configValues, ok := obj.(*kotsv1beta1.ConfigValues)
+	if !ok {
+		return nil, nil, errors.Errorf("unexpected type %T for config values", obj)
+	}
+
+	values := map[string]interface{}{}
+	filenames := map[string]interface{}{}
+	converted := map[string]bool{}
+	warnings := []string{}
+
+	for _, group := range kotsConfig.Spec.Groups {
+		for _, item := range group.Items {
+			configValue, ok := configValues.Spec.Values[item.Name]
+			if !ok {
+				continue
+			}
+			converted[item.Name] = true
+
+			value := configValue.ValuePlaintext
+			if value == "" && item.Type == "password" && configValue.Value != "" {
+				// kots encrypts the value of a password, only the plaintext can be used
+				warnings = append(warnings, fmt.Sprintf("%s is a password with only an encrypted value and was not converted, export the config values with kubectl kots get config --decrypt", item.Name))
+				continue
+			}
+			if value == "" {
+				value = configValue.Value
+			}
+			if value == "" && item.Type == "file" {
+				value = configValue.Data
+			}
+			if value == "" {
+				continue
+			}
+
+			helmValue, err := helmValueForConfigValue(item, value)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "failed to convert %s", item.Name)
+			}
+
+			setValuesPath(values, valuesOpts.configItemKeys(group, item), helmValue)
+
+			if item.Type == "file" && configValue.Filename != "" {
+				filenames[valuesOpts.key(item.Name)] = configValue.Filename
+			}
+		}
+	}
+
+	for name := range configValues.Spec.Values {
+		if !converted[name] {
+			warnings = append(warnings, fmt.Sprintf("%s is not an item in the config and was not converted", name))
+		}
+	}
+	sort.Strings(warnings)
+
+	if len(filenames) > 0 {
+		values[configFilenamesKey] = filenames
+	}
+
+	rendered, err := yaml.Marshal(values)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal values")
+	}
+
+	return rendered, warnings, nil
+}
+
+// HelmValuesToConfigValues converts helm values for the chart built from the kots config
+// back to a kots ConfigValues, so they can be imported into kots. values for items that
+// aren't in the config are returned as warnings
+func HelmValuesToConfigValues(configContent []byte, valuesContent []byte, valuesOpts ValuesOpts) ([]byte, []string, error) {
+	kotsConfig, err := decodeKOTSConfig(configContent)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	values := map[string]interface{}{}
+	if err := yaml.Unmarshal(valuesContent, &values); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to unmarshal values")
+	}
+
+	filenames, _ := values[configFilenamesKey].(map[string]interface{})
+
+	warnings := []string{}
+	configValues := map[string]interface{}{}
+	for _, group := range kotsConfig.Spec.Groups {
+		if groupKey, ok := valuesOpts.groupKey(group); ok {
+			if valuesGroup, ok := values[groupKey].(map[string]interface{}); ok {
+				warnings = append(warnings, unknownGroupValues(kotsConfig, valuesOpts, groupKey, valuesGroup)...)
+			}
+		}
+
+		for _, item := range group.Items {
+			helmValue, ok := getValuesPath(values, valuesOpts.configItemKeys(group, item))
+			if !ok || helmValue == nil {
+				continue
+			}
+
+			value, err := configValueForHelmValue(item, helmValue)
+			if err != nil {
+				return nil, nil, errors.Wrapf(err, "failed to convert %s", item.Name)
+			}
+			if value == "" {
+				continue
+			}
+
+			configValue := map[string]interface{}{}
+			if item.Default.String() != "" {
+				configValue["default"] = item.Default.String()
+			}
+			if item.Type == "password" {
+				// kots encrypts plaintext values when they're imported
+				configValue["valuePlaintext"] = value
+			} else {
+				configValue["value"] = value
+			}
+			if item.Type == "file" {
+				if filename, ok := filenames[valuesOpts.key(item.Name)].(string); ok && filename != "" {
+					configValue["filename"] = filename
+				}
+			}
+
+			configValues[item.Name] = configValue
+		}
+	}
+
+	name := kotsConfig.Name
+	if name == "" {
+		name = "config"
+	}
+
+	rendered, err := yaml.Marshal(map[string]interface{}{
+		"apiVersion": "kots.io/v1beta1",
+		"kind":       "ConfigValues",
+		"metadata": map[string]interface{}{
+			"name": name,
+		},
+		"spec": map[string]interface{}{
+			"values": configValues,
+		},
+	})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to marshal config values")
+	}
+
+	sort.Strings(warnings)
+	return rendered, warnings, nil
+}
+
+// unknownGroupValues returns a warning for each value in a group's values that isn't an item
+func unknownGroupValues(kotsConfig *kotsv1beta1.Config, valuesOpts ValuesOpts, groupKey string, valuesGroup map[string]interface{}) []string {
+	known := map[string]bool{}
+	for _, group := range kotsConfig.Spec.Groups {
+		for _, item := range group.Items {
+			keys := valuesOpts.configItemKeys(group, item)
+			if keys[0] == groupKey && len(keys) > 1 {
+				known[keys[1]] = true
+			}
+		}
+	}
+
+	warnings := []string{}
+	for key := range valuesGroup {
+		if !known[key] {
+			warnings = append(warnings, fmt.Sprintf("%s.%s is not an item in the config and was not converted", groupKey, key))
+		}
+	}
+
+	return warnings
+}
+
+func decodeKOTSConfig(content []byte) (*kotsv1beta1.Config, error) {
+	obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(content, nil, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode config")
+	}
+	kotsConfig, ok := obj.(*kotsv1beta1.Config)
+	if !ok {
+		return nil, errors.Errorf("unexpected type %T for config", obj)
+	}
+
+	return kotsConfig, nil
+}
+
+// helmValueForConfigValue converts a value from kots to its helm value. kots stores bools
+// as "1" and "0", the converted templates compare them with true and false
+func helmValueForConfigValue(item kotsv1beta1.ConfigItem, value string) (interface{}, error) {
+	if item.Type != "bool" {
+		return value, nil
+	}
+
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to parse bool")
+	}
+
+	return b, nil
+}
+
+// configValueForHelmValue converts a helm value to the string kots stores
+func configValueForHelmValue(item kotsv1beta1.ConfigItem, value interface{}) (string, error) {
+	if item.Type == "bool" {
+		b := false
+		switch v := value.(type) {
+		case bool:
+			b = v
+		case string:
+			if v == "" {
+				return "", nil
+			}
+			parsed, err := strconv.ParseBool(v)
+			if err != nil {
+				return "", errors.Wrap(err, "failed to parse bool")
+			}
+			b = parsed
+		default:
+			return "", errors.Errorf("unexpected type %T for bool", value)
+		}
+
+		if b {
+			return "1", nil
+		}
+		return "0", nil
+	}
+
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case map[string]interface{}, []interface{}:
+		return "", errors.Errorf("unexpected type %T", value)
+	}
+
+	return fmt.Sprint(value), nil
+}
@@ -0,0 +1,120 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testConfigValuesConfig = `apiVersion: kots.io/v1beta1
+kind: Config
+metadata:
+  name: my-app
+spec:
+  groups:
+    - name: database
+      items:
+        - name: db_host
+          type: text
+          default: postgres
+        - name: db_password
+          type: password
+        - name: api_key
+          type: password
+        - name: enable_metrics
+          type: bool
+          default: "1"
+        - name: ca_cert
+          type: file
+        - name: replicas
+          type: text
+          default: "2"
+`
+
+func Test_ConfigValuesToHelmValues(t *testing.T) {
+	req := require.New(t)
+
+	configValues := `apiVersion: kots.io/v1beta1
+kind: ConfigValues
+metadata:
+  name: my-app
+spec:
+  values:
+    db_host:
+      default: postgres
+      value: db.example.com
+    db_password:
+      valuePlaintext: hunter2
+    api_key:
+      value: cmVwbGljYXRlZC1lbmNyeXB0ZWQ=
+    enable_metrics:
+      default: "1"
+      value: "0"
+    ca_cert:
+      value: LS0tLS1CRUdJTg==
+      filename: ca.pem
+    replicas:
+      default: "2"
+    removed_item:
+      value: x
+`
+
+	actual, warnings, err := ConfigValuesToHelmValues([]byte(testConfigValuesConfig), []byte(configValues), ValuesOpts{})
+	req.NoError(err)
+
+	assert.Equal(t, `configFilenames:
+    ca_cert: ca.pem
+database:
+    ca_cert: LS0tLS1CRUdJTg==
+    db_host: db.example.com
+    db_password: hunter2
+    enable_metrics: false
+`, string(actual))
+	assert.Equal(t, []string{
+		"api_key is a password with only an encrypted value and was not converted, export the config values with kubectl kots get config --decrypt",
+		"removed_item is not an item in the config and was not converted",
+	}, warnings)
+}
+
+func Test_HelmValuesToConfigValues(t *testing.T) {
+	req := require.New(t)
+
+	values := `configFilenames:
+  ca_cert: ca.pem
+database:
+  ca_cert: LS0tLS1CRUdJTg==
+  db_host: db.example.com
+  db_password: hunter2
+  enable_metrics: true
+  replicas: 3
+  db_port: 5432
+isKurl: false
+`
+
+	actual, warnings, err := HelmValuesToConfigValues([]byte(testConfigValuesConfig), []byte(values), ValuesOpts{})
+	req.NoError(err)
+
+	assert.Equal(t, `apiVersion: kots.io/v1beta1
+kind: ConfigValues
+metadata:
+    name: my-app
+spec:
+    values:
+        ca_cert:
+            filename: ca.pem
+            value: LS0tLS1CRUdJTg==
+        db_host:
+            default: postgres
+            value: db.example.com
+        db_password:
+            valuePlaintext: hunter2
+        enable_metrics:
+            default: "1"
+            value: "1"
+        replicas:
+            default: "2"
+            value: "3"
+`, string(actual))
+	assert.Equal(t, []string{"database.db_port is not an item in the config and was not converted"}, warnings)
+}
@@ -0,0 +1,204 @@
+package builder
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots2helm/pkg/logger"
+	"gopkg.in/yaml.v2"
+)
+
+var (
+	yamlAPIVersionRegex     = regexp.MustCompile(`(?m)^apiVersion:\s*["']?([\w./-]+)`)
+	whenOrExcludeAnnotation = regexp.MustCompile(`kots\.io/(?:when|exclude)["']?\s*:`)
+	kotsTemplateRegex       = regexp.MustCompile(`{{repl|repl{{`)
+	kotsActionRegex         = regexp.MustCompile(`(?:{{repl|repl{{)\s*(.*?)\s*}}`)
+)
+
+// moveCRDs moves the CustomResourceDefinitions in the templates dir of workspace to the
+// crds dir, so helm installs them before any custom resources and doesn't delete them on
+// uninstall. this runs before conversion, because helm doesn't template the crds dir
+func moveCRDs(workspace string, opts conversionOpts) error {
+	files, err := scanTemplateFiles(workspace)
+	if err != nil {
+		return errors.Wrap(err, "failed to walk workspace")
+	}
+
+	for _, path := range files {
+		if err := moveCRDsInFile(workspace, path, opts); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// moveCRDsInFile moves the CustomResourceDefinition documents in the template at path to
+// the same path in the crds dir. kots templates that don't need values are rendered first.
+// CRDs that still need templating are left in the template, or fail the build when
+// unconverted functions do
+func moveCRDsInFile(workspace string, path string, opts conversionOpts) error {
+	rel, err := filepath.Rel(filepath.Join(workspace, "templates"), path)
+	if err != nil {
+		return errors.Wrapf(err, "failed to get relative path for %s", path)
+	}
+	crdPath := filepath.Join(workspace, "crds", rel)
+
+	// the file may have been moved before it changed
+	if err := os.RemoveAll(crdPath); err != nil {
+		return errors.Wrapf(err, "failed to remove %s", crdPath)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	docs := yamlDocumentSeparatorRegex.Split(string(content), -1)
+	kept := []string{}
+	moved := []string{}
+	for i, doc := range docs {
+		if !isCRD(doc) {
+			kept = append(kept, doc)
+			continue
+		}
+
+		resolved := doc
+		if kotsTemplateRegex.MatchString(doc) {
+			if staticDoc, ok := resolveStaticKOTSTemplates(doc); ok {
+				resolved = staticDoc
+			}
+		}
+
+		if crdNeedsTemplating(resolved, opts) {
+			if opts.UnconvertedPolicy == UnconvertedPolicyFail {
+				return errors.Errorf("templates/%s: CustomResourceDefinition %s uses templates that need values, helm doesn't template crds/", rel, crdName(doc, i))
+			}
+			logger.Warnf("templates/%s: CustomResourceDefinition %s uses templates that need values and was left in templates/, helm doesn't template crds/", rel, crdName(doc, i))
+			kept = append(kept, doc)
+			continue
+		}
+
+		moved = append(moved, resolved)
+	}
+
+	if len(moved) == 0 {
+		return nil
+	}
+
+	logger.Verbosef("moving %d CustomResourceDefinitions from templates/%s to crds/%s", len(moved), rel, rel)
+
+	if err := os.MkdirAll(filepath.Dir(crdPath), 0755); err != nil {
+		return errors.Wrap(err, "failed to create crds dir")
+	}
+	if err := ioutil.WriteFile(crdPath, []byte(joinYAMLDocuments(moved)), info.Mode()); err != nil {
+		return errors.Wrapf(err, "failed to write %s", crdPath)
+	}
+
+	remaining := joinYAMLDocuments(kept)
+	if isEmptyYAMLDocument(yamlDocumentSeparatorRegex.ReplaceAllString(remaining, "")) {
+		if err := os.Remove(path); err != nil {
+			return errors.Wrapf(err, "failed to remove %s", path)
+		}
+		return nil
+	}
+
+	if err := ioutil.WriteFile(path, []byte(remaining), info.Mode()); err != nil {
+		return errors.Wrapf(err, "failed to write %s", path)
+	}
+
+	return nil
+}
+
+// isCRD is true for CustomResourceDefinition documents, even when they have templates
+// that aren't valid yaml
+func isCRD(doc string) bool {
+	kind := yamlKindRegex.FindStringSubmatch(doc)
+	apiVersion := yamlAPIVersionRegex.FindStringSubmatch(doc)
+	if kind == nil || apiVersion == nil {
+		return false
+	}
+
+	return kind[1] == "CustomResourceDefinition" && strings.HasPrefix(apiVersion[1], "apiextensions.k8s.io/")
+}
+
+// crdNeedsTemplating is true when the CRD has kots templates, is conditionally included,
+// or references a resource that the conversion would rewrite. other {{ }} are literal text,
+// which is copied as it is because helm doesn't template crds/
+func crdNeedsTemplating(doc string, opts conversionOpts) bool {
+	if kotsTemplateRegex.MatchString(doc) || whenOrExcludeAnnotation.MatchString(doc) {
+		return true
+	}
+
+	// conversion webhooks reference a service by name and namespace
+	if rewriteNamespacesInDocument(doc, opts.Namespaces, releaseNamespace) != doc {
+		return true
+	}
+	if opts.PrefixedNames != nil && prefixNamesInDocument(doc, opts.ChartName, opts.PrefixedNames, opts.PrefixedHosts) != doc {
+		return true
+	}
+
+	return false
+}
+
+// resolveStaticKOTSTemplates renders the kots templates in doc that only use sprig functions
+// and literals, such as repl{{ printf "v%d" 1 }}. it's false when any of them read config,
+// the license or the release, or use a function that only kots has
+func resolveStaticKOTSTemplates(doc string) (string, bool) {
+	funcs := sprig.TxtFuncMap()
+	// the same as helm, templates can't read the environment of the build
+	delete(funcs, "env")
+	delete(funcs, "expandenv")
+
+	resolved := true
+	updated := kotsActionRegex.ReplaceAllStringFunc(doc, func(action string) string {
+		pipeline := kotsActionRegex.FindStringSubmatch(action)[1]
+		t, err := template.New("crd").Funcs(funcs).Option("missingkey=error").Parse("{{ " + pipeline + " }}")
+		if err != nil {
+			resolved = false
+			return action
+		}
+
+		var rendered bytes.Buffer
+		if err := t.Execute(&rendered, map[string]interface{}{}); err != nil {
+			resolved = false
+			return action
+		}
+		return rendered.String()
+	})
+	if !resolved {
+		return doc, false
+	}
+
+	return updated, true
+}
+
+func crdName(doc string, idx int) string {
+	r := struct {
+		Metadata struct {
+			Name string `yaml:"name"`
+		} `yaml:"metadata"`
+	}{}
+	if err := yaml.Unmarshal([]byte(doc), &r); err == nil && r.Metadata.Name != "" {
+		return r.Metadata.Name
+	}
+
+	return fmt.Sprintf("in document %d", idx+1)
+}
+
+// joinYAMLDocuments joins documents split by yamlDocumentSeparatorRegex
+func joinYAMLDocuments(docs []string) string {
+	return strings.TrimLeft(strings.Join(docs, "---"), "\n")
+}
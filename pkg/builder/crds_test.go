@@ -0,0 +1,175 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testCRD = `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+spec:
+  group: example.com
+  names:
+    kind: Widget
+    plural: widgets
+  scope: Namespaced
+`
+
+func Test_moveCRDsInFile(t *testing.T) {
+	tests := []struct {
+		name          string
+		content       string
+		opts          conversionOpts
+		wantTemplate  string
+		wantCRD       string
+		templateGone  bool
+		crdNotCreated bool
+		wantErr       string
+	}{
+		{
+			name:         "only a crd",
+			content:      testCRD,
+			wantCRD:      testCRD,
+			templateGone: true,
+		},
+		{
+			name: "crd in a multi doc file",
+			content: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: widget-config
+---
+` + testCRD + `---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+`,
+			wantTemplate: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: widget-config
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: widget
+`,
+			wantCRD: testCRD,
+		},
+		{
+			name: "templated crd",
+			content: testCRD + `  versions:
+    - name: v1
+      served: repl{{ ConfigOptionEquals "served" "1" }}
+`,
+			wantTemplate: testCRD + `  versions:
+    - name: v1
+      served: repl{{ ConfigOptionEquals "served" "1" }}
+`,
+			crdNotCreated: true,
+		},
+		{
+			name: "templated crd fails when unconverted functions do",
+			opts: conversionOpts{UnconvertedPolicy: UnconvertedPolicyFail},
+			content: testCRD + `  versions:
+    - name: v1
+      served: repl{{ ConfigOptionEquals "served" "1" }}
+`,
+			wantErr: "templates/app/widgets.yaml: CustomResourceDefinition widgets.example.com uses templates that need values, helm doesn't template crds/",
+		},
+		{
+			name: "static templates are rendered",
+			opts: conversionOpts{UnconvertedPolicy: UnconvertedPolicyFail},
+			content: testCRD + `  versions:
+    - name: repl{{ printf "v%d" 1 }}
+      served: {{repl "true" }}
+`,
+			wantCRD: testCRD + `  versions:
+    - name: v1
+      served: true
+`,
+			templateGone: true,
+		},
+		{
+			name: "conditional crd",
+			content: `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+  annotations:
+    kots.io/when: '{{repl ConfigOptionEquals "widgets" "1" }}'
+`,
+			wantTemplate: `apiVersion: apiextensions.k8s.io/v1
+kind: CustomResourceDefinition
+metadata:
+  name: widgets.example.com
+  annotations:
+    kots.io/when: '{{repl ConfigOptionEquals "widgets" "1" }}'
+`,
+			crdNotCreated: true,
+		},
+		{
+			name: "conversion webhook in the app namespace",
+			opts: conversionOpts{Namespaces: namespaceOpts{AppNamespaces: []string{"default"}}},
+			content: testCRD + `  conversion:
+    strategy: Webhook
+    webhook:
+      clientConfig:
+        service:
+          name: widget-webhook
+          namespace: default
+`,
+			wantTemplate: testCRD + `  conversion:
+    strategy: Webhook
+    webhook:
+      clientConfig:
+        service:
+          name: widget-webhook
+          namespace: default
+`,
+			crdNotCreated: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+
+			workspace := t.TempDir()
+			path := filepath.Join(workspace, "templates", "app", "widgets.yaml")
+			req.NoError(os.MkdirAll(filepath.Dir(path), 0755))
+			req.NoError(ioutil.WriteFile(path, []byte(tt.content), 0644))
+
+			err := moveCRDsInFile(workspace, path, tt.opts)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				return
+			}
+			req.NoError(err)
+
+			crdPath := filepath.Join(workspace, "crds", "app", "widgets.yaml")
+			if tt.crdNotCreated {
+				assert.NoFileExists(t, crdPath)
+			} else {
+				crd, err := ioutil.ReadFile(crdPath)
+				req.NoError(err)
+				assert.Equal(t, tt.wantCRD, string(crd))
+			}
+
+			if tt.templateGone {
+				assert.NoFileExists(t, path)
+			} else {
+				template, err := ioutil.ReadFile(path)
+				req.NoError(err)
+				assert.Equal(t, tt.wantTemplate, string(template))
+			}
+		})
+	}
+}
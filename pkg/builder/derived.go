@@ -0,0 +1,207 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/replicatedhq/kots2helm/pkg/logger"
+)
+
+// configItemFunctionRegex matches the name of the config item that a ConfigOption function reads
+var configItemFunctionRegex = regexp.MustCompile("ConfigOption(?:Equals|NotEquals|Data|Filename)?\\s+[\"`]([^\"`]+)[\"`]")
+
+// derivedConfigItem is a config item whose value or default is a template of other items,
+// like repl{{ ConfigOption "hostname" }}:443. the chart renders the template when the item
+// isn't set in the values
+type derivedConfigItem struct {
+	Name string
+	// Source is value or default, the field of the item the expression is from
+	Source string
+	// ValuesReference is the template expression for the item's value in .Values
+	ValuesReference string
+	// Expression is the helm template converted from the kots one
+	Expression string
+	// When is the template condition for the item being shown, empty when it always is
+	When string
+	Bool bool
+}
+
+// configItemTemplate returns the template kots renders for an item, the value takes
+// precedence over the default
+func configItemTemplate(item kotsv1beta1.ConfigItem) (string, string) {
+	if value := item.Value.String(); value != "" {
+		return value, "value"
+	}
+
+	return item.Default.String(), "default"
+}
+
+// getDerivedConfigItemNames returns the names of the config items that are derived from
+// other items. an item is only derived when every function in its template can be
+// converted, the rest keep their template as a plain string like before
+func getDerivedConfigItemNames(kotsConfig *kotsv1beta1.Config, chartName string, valuesOpts ValuesOpts) (map[string]bool, error) {
+	derived := map[string]bool{}
+
+	for _, group := range kotsConfig.Spec.Groups {
+		for _, item := range group.Items {
+			template, source := configItemTemplate(item)
+			if !kotsTemplateRegex.MatchString(template) || isRandomConfigItem(group, item) {
+				continue
+			}
+
+			expression, err := convertConfigItemExpression(template, kotsConfig, chartName, valuesOpts)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to convert the %s of %s", source, item.Name)
+			}
+			if kotsTemplateRegex.MatchString(expression) {
+				logger.Warnf("config item %s has template functions in its %s that could not be converted, it's used as a plain string", item.Name, source)
+				continue
+			}
+
+			derived[item.Name] = true
+		}
+	}
+
+	return derived, nil
+}
+
+// validateConfigItemDependencies returns an error when the config items that are rendered
+// by named templates depend on each other in a cycle. derived items depend on the items in
+// their template, conditional items on the items in their when and their group's when
+func validateConfigItemDependencies(kotsConfig *kotsv1beta1.Config, valuesOpts ValuesOpts) error {
+	dependencies := map[string][]string{}
+	order := []string{}
+
+	for _, group := range kotsConfig.Spec.Groups {
+		for _, item := range group.Items {
+			templates := []string{}
+			if valuesOpts.Derived[item.Name] {
+				template, _ := configItemTemplate(item)
+				templates = append(templates, template)
+			}
+			if valuesOpts.Conditional[item.Name] {
+				templates = append(templates, string(group.When), string(item.When))
+			}
+			if len(templates) == 0 {
+				continue
+			}
+
+			order = append(order, item.Name)
+			for _, template := range templates {
+				for _, m := range configItemFunctionRegex.FindAllStringSubmatch(template, -1) {
+					dependencies[item.Name] = append(dependencies[item.Name], m[1])
+				}
+			}
+		}
+	}
+
+	if cycle := findDependencyCycle(order, dependencies); cycle != nil {
+		return errors.Errorf("config items can't depend on each other in a cycle: %s", strings.Join(cycle, " -> "))
+	}
+
+	return nil
+}
+
+// findDependencyCycle returns the first cycle in the graph as a path that starts and ends
+// with the same name, or nil when there isn't one
+func findDependencyCycle(names []string, dependencies map[string][]string) []string {
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := map[string]int{}
+	path := []string{}
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		switch state[name] {
+		case visited:
+			return nil
+		case visiting:
+			for i, n := range path {
+				if n == name {
+					return append(append([]string{}, path[i:]...), name)
+				}
+			}
+		}
+
+		state[name] = visiting
+		path = append(path, name)
+		for _, dependency := range dependencies[name] {
+			if cycle := visit(dependency); cycle != nil {
+				return cycle
+			}
+		}
+		path = path[:len(path)-1]
+		state[name] = visited
+
+		return nil
+	}
+
+	for _, name := range names {
+		if cycle := visit(name); cycle != nil {
+			return cycle
+		}
+	}
+
+	return nil
+}
+
+// convertConfigItemExpression converts the kots template of a config item to a helm one,
+// the same as a template in a manifest
+func convertConfigItemExpression(template string, kotsConfig *kotsv1beta1.Config, chartName string, valuesOpts ValuesOpts) (string, error) {
+	helmifyOpts := HelmifyOpts{
+		FullExpandConfigOptionEqualsToIfElseEnd: true,
+		ChartName:                               chartName,
+		Values:                                  valuesOpts,
+	}
+
+	content, err := helmify(escapeLiteralTemplates([]byte(template)), kotsConfig, helmifyOpts)
+	if err != nil {
+		return "", err
+	}
+
+	return string(content), nil
+}
+
+// getDerivedConfigItems returns the derived config items with their converted expressions
+func getDerivedConfigItems(kotsConfig *kotsv1beta1.Config, chartName string, valuesOpts ValuesOpts) ([]derivedConfigItem, error) {
+	derivedItems := []derivedConfigItem{}
+
+	for _, group := range kotsConfig.Spec.Groups {
+		for _, item := range group.Items {
+			if !valuesOpts.Derived[item.Name] {
+				continue
+			}
+
+			template, source := configItemTemplate(item)
+			expression, err := convertConfigItemExpression(template, kotsConfig, chartName, valuesOpts)
+			if err != nil {
+				return nil, errors.Wrapf(err, "failed to convert the %s of %s", source, item.Name)
+			}
+			condition, err := getConfigItemCondition(group, item, kotsConfig, chartName, valuesOpts)
+			if err != nil {
+				return nil, err
+			}
+
+			derivedItems = append(derivedItems, derivedConfigItem{
+				Name:            item.Name,
+				Source:          source,
+				ValuesReference: operand(configItemValuesReference(group, item, valuesOpts)),
+				Expression:      expression,
+				When:            condition,
+				Bool:            item.Type == "bool",
+			})
+		}
+	}
+
+	return derivedItems, nil
+}
+
+// configItemInclude returns the include of the named template for a config item in _helpers.tpl
+func configItemInclude(chartName string, item kotsv1beta1.ConfigItem) string {
+	return fmt.Sprintf(`include "%s.config.%s" $`, chartName, item.Name)
+}
@@ -0,0 +1,120 @@
+package builder
+
+import (
+	"testing"
+
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/replicatedhq/kots/kotskinds/multitype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_getDerivedConfigItems(t *testing.T) {
+	kotsConfig := &kotsv1beta1.Config{
+		Spec: kotsv1beta1.ConfigSpec{
+			Groups: []kotsv1beta1.ConfigGroup{
+				{
+					Name: "network",
+					Items: []kotsv1beta1.ConfigItem{
+						{Name: "hostname", Type: "text", Default: multitype.FromString("example.com")},
+						{Name: "external_url", Type: "text", Value: multitype.FromString(`https://repl{{ ConfigOption "hostname" }}:443`)},
+						{Name: "api_url", Type: "text", Default: multitype.FromString(`{{repl ConfigOption "external_url" }}/api`)},
+						{Name: "mode", Type: "select_one", Default: multitype.FromString("basic")},
+						{Name: "advanced", Type: "bool", Default: multitype.FromString(`{{repl ConfigOptionEquals "mode" "advanced" }}`)},
+						{Name: "license", Type: "text", Default: multitype.FromString(`{{repl LicenseFieldValue "foo" }}`)},
+						{Name: "password", Type: "password", Value: multitype.FromString(`{{repl RandomString 16 }}`)},
+					},
+				},
+			},
+		},
+	}
+
+	valuesOpts := ValuesOpts{}
+	derived, err := getDerivedConfigItemNames(kotsConfig, "app", valuesOpts)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{
+		"external_url": true,
+		"api_url":      true,
+		"advanced":     true,
+	}, derived)
+
+	valuesOpts.Derived = derived
+	derivedItems, err := getDerivedConfigItems(kotsConfig, "app", valuesOpts)
+	require.NoError(t, err)
+	assert.Equal(t, []derivedConfigItem{
+		{
+			Name:            "external_url",
+			Source:          "value",
+			ValuesReference: `(dig "network" "external_url" "" .Values.AsMap)`,
+			Expression:      `https://{{ dig "network" "hostname" "example.com" .Values.AsMap }}:443`,
+		},
+		{
+			Name:            "api_url",
+			Source:          "default",
+			ValuesReference: `(dig "network" "api_url" "" .Values.AsMap)`,
+			Expression:      `{{ include "app.config.external_url" $ }}/api`,
+		},
+		{
+			Name:            "advanced",
+			Source:          "default",
+			ValuesReference: `(dig "network" "advanced" nil .Values.AsMap)`,
+			Expression:      `{{ if eq (dig "network" "mode" "basic" .Values.AsMap) "advanced" }}true{{ else }}false{{ end }}`,
+			Bool:            true,
+		},
+	}, derivedItems)
+
+	converted, err := replaceConfigOptionEquals([]byte(`{{repl ConfigOptionEquals "advanced" "0" }}`), kotsConfig, "app", valuesOpts, true)
+	require.NoError(t, err)
+	assert.Equal(t, `{{ if has (include "app.config.advanced" $) (list "0" "false") }}true{{ else }}false{{ end }}`, string(converted))
+
+	values := getValuesFromConfig(kotsConfig, valuesOpts)
+	assert.Equal(t, map[string]interface{}{
+		"hostname":     "example.com",
+		"external_url": "",
+		"api_url":      "",
+		"mode":         "basic",
+		"advanced":     nil,
+		"license":      `{{repl LicenseFieldValue "foo" }}`,
+		"password":     "",
+	}, values["network"])
+}
+
+func Test_validateConfigItemDependencies(t *testing.T) {
+	kotsConfig := &kotsv1beta1.Config{
+		Spec: kotsv1beta1.ConfigSpec{
+			Groups: []kotsv1beta1.ConfigGroup{
+				{
+					Name: "network",
+					Items: []kotsv1beta1.ConfigItem{
+						{Name: "a", Type: "text", Default: multitype.FromString(`repl{{ ConfigOption "c" }}`)},
+						{Name: "b", Type: "text", Default: multitype.FromString(`repl{{ ConfigOption "a" }}`)},
+						{Name: "c", Type: "text", Default: multitype.FromString(`{{repl ConfigOption "b" }}`)},
+						{Name: "d", Type: "text", Default: multitype.FromString(`{{repl ConfigOption "d" }}`)},
+					},
+				},
+			},
+		},
+	}
+
+	derived, err := getDerivedConfigItemNames(kotsConfig, "app", ValuesOpts{})
+	require.NoError(t, err)
+	err = validateConfigItemDependencies(kotsConfig, ValuesOpts{Derived: derived})
+	assert.EqualError(t, err, "config items can't depend on each other in a cycle: a -> c -> b -> a")
+
+	kotsConfig.Spec.Groups[0].Items = kotsConfig.Spec.Groups[0].Items[3:]
+	derived, err = getDerivedConfigItemNames(kotsConfig, "app", ValuesOpts{})
+	require.NoError(t, err)
+	err = validateConfigItemDependencies(kotsConfig, ValuesOpts{Derived: derived})
+	assert.EqualError(t, err, "config items can't depend on each other in a cycle: d -> d")
+
+	// a conditional item depends on the items in its when
+	kotsConfig.Spec.Groups[0].Items = []kotsv1beta1.ConfigItem{
+		{Name: "e", Type: "text", When: `repl{{ ConfigOptionEquals "f" "x" }}`},
+		{Name: "f", Type: "text", Default: multitype.FromString(`{{repl ConfigOption "e" }}`)},
+	}
+	err = validateConfigItemDependencies(kotsConfig, ValuesOpts{
+		Derived:     map[string]bool{"f": true},
+		Conditional: map[string]bool{"e": true},
+	})
+	assert.EqualError(t, err, "config items can't depend on each other in a cycle: e -> f -> e")
+}
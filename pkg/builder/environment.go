@@ -0,0 +1,80 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// kotsVersionRequiredMessage is the error when KotsVersion is used without .Values.kotsVersion.
+// there's no default, since a guess would pick the wrong side of a version comparison
+const kotsVersionRequiredMessage = "kotsVersion must be set to the version of kots the app was installed with"
+
+var (
+	// templateActionRegex matches a single template action, with or without the repl delimiters
+	templateActionRegex = regexp.MustCompile(`(?s)(?:repl)?{{.*?}}`)
+
+	// environmentFunctionStatementRegex matches an environment function at the start of a kots template
+	environmentFunctionStatementRegex = regexp.MustCompile(`((?:{{repl|repl{{)\s*)(Distribution|IsAirgap|KotsVersion|HTTPProxy|HTTPSProxy|NoProxy)\b`)
+	// environmentFunctionRegex matches an environment function anywhere in a template action
+	// outside of a string. the prefix stops values paths such as .Values.group.Distribution
+	// from matching
+	environmentFunctionRegex = regexp.MustCompile(`(^|[^.\w"])(Distribution|IsAirgap|KotsVersion|HTTPProxy|HTTPSProxy|NoProxy)\b`)
+)
+
+// replaceEnvironmentFunctions converts the functions that describe the environment kots
+// is running in. Distribution is detected by a named template in _helpers.tpl, the rest
+// are read from values
+func replaceEnvironmentFunctions(content []byte, chartName string) ([]byte, error) {
+	updatedContent := environmentFunctionStatementRegex.ReplaceAllStringFunc(string(content), func(match string) string {
+		result := environmentFunctionStatementRegex.FindStringSubmatch(match)
+		return result[1] + environmentFunctionValue(result[2], chartName)
+	})
+
+	updatedContent = templateActionRegex.ReplaceAllStringFunc(updatedContent, func(action string) string {
+		return replaceOutsideTemplateStrings(action, func(s string) string {
+			return environmentFunctionRegex.ReplaceAllStringFunc(s, func(match string) string {
+				result := environmentFunctionRegex.FindStringSubmatch(match)
+				return fmt.Sprintf("%s(%s)", result[1], environmentFunctionValue(result[2], chartName))
+			})
+		})
+	})
+
+	return []byte(updatedContent), nil
+}
+
+func environmentFunctionValue(function string, chartName string) string {
+	switch function {
+	case "Distribution":
+		return fmt.Sprintf("include %q $", chartName+".distribution")
+	case "IsAirgap":
+		return ".Values.isAirgap"
+	case "KotsVersion":
+		return fmt.Sprintf("required %q .Values.kotsVersion", kotsVersionRequiredMessage)
+	case "HTTPProxy":
+		return ".Values.proxy.httpProxy"
+	case "HTTPSProxy":
+		return ".Values.proxy.httpsProxy"
+	case "NoProxy":
+		return ".Values.proxy.noProxy"
+	}
+
+	return function
+}
+
+// replaceOutsideTemplateStrings calls replace with each part of a template action that isn't
+// in a string, so a string such as a required message is left as it is
+func replaceOutsideTemplateStrings(action string, replace func(string) string) string {
+	updated := ""
+	start := 0
+	for i := 0; i < len(action); {
+		if action[i] != '"' && action[i] != '`' {
+			i++
+			continue
+		}
+		end := skipTemplateString(action, i)
+		updated += replace(action[start:i]) + action[i:end]
+		start, i = end, end
+	}
+
+	return updated + replace(action[start:])
+}
@@ -0,0 +1,70 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_replaceEnvironmentFunctions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		expect  string
+	}{
+		{
+			name:    "distribution",
+			content: `dist: repl{{ Distribution }}`,
+			expect:  `dist: {{ include "app.distribution" $ }}`,
+		},
+		{
+			name:    "distribution comparison",
+			content: `{{repl if eq Distribution "openShift" }}`,
+			expect:  `{{ if eq (include "app.distribution" $) "openShift" }}`,
+		},
+		{
+			name:    "airgap",
+			content: `airgap: '{{repl IsAirgap }}'`,
+			expect:  `airgap: '{{ .Values.isAirgap }}'`,
+		},
+		{
+			name:    "kots version comparison",
+			content: `{{repl if semverCompare ">=1.50.0" KotsVersion }}`,
+			expect:  `{{ if semverCompare ">=1.50.0" (required "kotsVersion must be set to the version of kots the app was installed with" .Values.kotsVersion) }}`,
+		},
+		{
+			name:    "in a string",
+			content: `{{repl required "Proxy settings: NoProxy is required" (ConfigOption "no_proxy") }}`,
+			expect:  `{{repl required "Proxy settings: NoProxy is required" (ConfigOption "no_proxy") }}`,
+		},
+		{
+			name:    "after a string",
+			content: `{{repl printf "%s,%s" "localhost" NoProxy }}`,
+			expect:  `{{ printf "%s,%s" "localhost" (.Values.proxy.noProxy) }}`,
+		},
+		{
+			name:    "proxies",
+			content: `value: repl{{ HTTPProxy }},repl{{ HTTPSProxy }},repl{{ NoProxy }}`,
+			expect:  `value: {{ .Values.proxy.httpProxy }},{{ .Values.proxy.httpsProxy }},{{ .Values.proxy.noProxy }}`,
+		},
+		{
+			name:    "not in a template",
+			content: `description: Distribution of IsAirgap things`,
+			expect:  `description: Distribution of IsAirgap things`,
+		},
+		{
+			name:    "values path with the same name",
+			content: `{{ .Values.group1.Distribution }}`,
+			expect:  `{{ .Values.group1.Distribution }}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			actual, err := replaceEnvironmentFunctions([]byte(tt.content), "app")
+			req.NoError(err)
+			assert.Equal(t, tt.expect, string(convertStatementDelimiters(actual)))
+		})
+	}
+}
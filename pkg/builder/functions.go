@@ -8,30 +8,105 @@ import (
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/pkg/errors"
 	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
 	"github.com/replicatedhq/kots2helm/pkg/logger"
 )
 
+// conversionOpts are the options that change how a file is converted. everything
+// that is serialized here is part of the cache key for converted files
+type conversionOpts struct {
+	UnconvertedPolicy UnconvertedPolicy `json:"unconvertedPolicy"`
+	ChartName         string            `json:"chartName"`
+	// PrefixedNames are the resource names, by kind, to prefix with the release fullname
+	PrefixedNames map[string][]string `json:"prefixedNames,omitempty"`
+	// PrefixedHosts are the host patterns of the prefixed Services, made from PrefixedNames
+	PrefixedHosts []prefixedHost `json:"-"`
+	Namespaces    namespaceOpts  `json:"namespaces"`
+	Values        ValuesOpts     `json:"values"`
+
+	Cache *conversionCache `json:"-"`
+	// Concurrency is the number of files converted at the same time
+	Concurrency int `json:"-"`
+}
+
+type fileConversionResult struct {
+	remaining int
+	err       error
+}
+
 // replaceKOTSTemplatesWithHelmTemplates handles converting kots templates to helm templates
-func replaceKOTSTemplatesWithHelmTemplates(workspace string) (map[string]int, error) {
-	objP, err := getKOTSKind(workspace, "kots.io", "v1beta1", "Config")
+// and returns the number of template functions that could not be converted in each file.
+// files are converted by a pool of workers, but results are reported in file order
+func replaceKOTSTemplatesWithHelmTemplates(workspace string, index *workspaceIndex, opts conversionOpts) (map[string]int, error) {
+	kotsConfig, err := getKOTSConfig(index)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get config")
 	}
-	if objP == nil {
-		// there isn't a kots config
-		// TODO: should we error here?
-		return nil, nil // no maybe its plain k8s
+
+	// without a kots config this may be plain k8s, but we still convert
+	// (and count) the functions that don't need one
+	if kotsConfig == nil {
+		kotsConfig = &kotsv1beta1.Config{}
+	}
+
+	files, err := scanTemplateFiles(workspace)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to walk workspace")
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	results := make([]fileConversionResult, len(files))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				remaining, err := convertTemplateFile(workspace, files[i], kotsConfig, opts)
+				results[i] = fileConversionResult{
+					remaining: remaining,
+					err:       err,
+				}
+			}
+		}()
 	}
 
-	obj := *objP
-	kotsConfig := obj.(*kotsv1beta1.Config)
+	for i := range files {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
 
 	remainingKotsTemplateFunctionsMap := map[string]int{}
+	for i, result := range results {
+		if result.err != nil {
+			return nil, result.err
+		}
+
+		if result.remaining > 0 {
+			fmt.Printf("%s has %d kots template functions\n", files[i], result.remaining)
+			pathWithoutWorkspace := strings.Replace(files[i], workspace+"/", "", 1)
+			remainingKotsTemplateFunctionsMap[pathWithoutWorkspace] = result.remaining
+		}
+	}
+
+	return remainingKotsTemplateFunctionsMap, nil
+}
+
+// scanTemplateFiles lists the files in the templates dir of workspace that may need converting
+func scanTemplateFiles(workspace string) ([]string, error) {
+	files := []string{}
 
-	err = filepath.Walk(filepath.Join(workspace, "templates"),
+	err := filepath.Walk(filepath.Join(workspace, "templates"),
 		func(path string, info os.FileInfo, err error) error {
 			if err != nil {
 				fmt.Printf("prevent panic by handling failure accessing a path %q: %v\n", path, err)
@@ -48,63 +123,133 @@ func replaceKOTSTemplatesWithHelmTemplates(workspace string) (map[string]int, er
 				return nil
 			}
 
-			content, err := ioutil.ReadFile(path)
-			if err != nil {
-				return err
-			}
+			files = append(files, path)
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
 
-			isKots, err := isKOTSManifest(content)
-			if err != nil {
-				return err
-			}
-			if isKots {
-				return nil
-			}
+	return files, nil
+}
 
-			logger.Verbosef("processing file: %q", path)
+// convertTemplateFile converts the file at path in place, returning the number of
+// kots template functions that remain
+func convertTemplateFile(workspace string, path string, kotsConfig *kotsv1beta1.Config, opts conversionOpts) (int, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
 
-			content, err = replaceWhenAndExcludeAnnotations(content, kotsConfig)
-			if err != nil {
-				return errors.Wrapf(err, "replaceWhenAndExcludeAnnotations for %q", path)
-			}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
 
-			opts := HelmifyOpts{
-				FullExpandConfigOptionEqualsToIfElseEnd: true,
-			}
-			content, err = helmify(content, kotsConfig, opts)
-			if err != nil {
-				return errors.Wrap(err, "failed to helmify")
-			}
+	isKots, err := isKOTSManifest(content)
+	if err != nil {
+		return 0, err
+	}
+	if isKots {
+		return 0, nil
+	}
 
-			// assert that there are no {{repl or repl{{ templates left.
-			// if there are, we need to fail the build
-			hasTemplateFunctions, err := numKotsTemplateFunctions(path, content, true)
-			if err != nil {
-				return errors.Wrap(err, "failed to check for kots template functions")
-			}
+	pathWithoutWorkspace := strings.Replace(path, workspace+"/", "", 1)
 
-			if hasTemplateFunctions > 0 {
+	converted, remaining, err := convertFile(path, pathWithoutWorkspace, content, kotsConfig, opts)
+	if err != nil {
+		return 0, err
+	}
 
-				fmt.Printf("%s has %d kots template functions\n", path, hasTemplateFunctions)
-				pathWithoutWorkspace := strings.Replace(path, workspace+"/", "", 1)
-				remainingKotsTemplateFunctionsMap[pathWithoutWorkspace] = hasTemplateFunctions
-			}
+	if err := ioutil.WriteFile(path, converted, info.Mode()); err != nil {
+		return 0, err
+	}
 
-			if err := ioutil.WriteFile(path, content, info.Mode()); err != nil {
-				return err
-			}
-			return nil
-		})
+	return remaining, nil
+}
+
+// convertFile converts the kots templates in a single file, using the cache when there
+// is one. the converted content and number of functions that remain are returned
+func convertFile(path string, pathWithoutWorkspace string, content []byte, kotsConfig *kotsv1beta1.Config, opts conversionOpts) ([]byte, int, error) {
+	cacheKey := ""
+	if opts.Cache != nil {
+		cacheKey = opts.Cache.key(pathWithoutWorkspace, content)
+		cached, err := opts.Cache.get(cacheKey)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "failed to read cache for %q", path)
+		}
+		if cached != nil {
+			logger.Verbosef("using cached conversion for file: %q", path)
+			return cached.Content, cached.Remaining, nil
+		}
+	}
+
+	logger.Verbosef("processing file: %q", path)
 
+	helmifyOpts := HelmifyOpts{
+		FullExpandConfigOptionEqualsToIfElseEnd: true,
+		ChartName:                               opts.ChartName,
+		Values:                                  opts.Values,
+	}
+
+	content = escapeLiteralTemplates(content)
+
+	content, err := replaceWhenAndExcludeAnnotations(content, kotsConfig, helmifyOpts)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to walk workspace")
+		return nil, 0, errors.Wrapf(err, "replaceWhenAndExcludeAnnotations for %q", path)
 	}
 
-	return remainingKotsTemplateFunctionsMap, nil
+	content = rewritePasswordEnvValues(content, kotsConfig, opts.ChartName, opts.Values)
+	for _, itemName := range getInlinedPasswordItems(content, kotsConfig) {
+		logger.Warnf("%s: password config item %s is used outside of an env var value and is inlined, its passwords existingSecret does not apply there", path, itemName)
+	}
+
+	content, err = helmify(content, kotsConfig, helmifyOpts)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to helmify")
+	}
+
+	content = insertConfigReferences(content, kotsConfig, opts.ChartName, opts.Values)
+
+	content = injectLabels(content, opts.ChartName)
+
+	if opts.PrefixedNames != nil {
+		content = prefixNames(content, opts.ChartName, opts.PrefixedNames, opts.PrefixedHosts)
+	}
+
+	content = rewriteNamespaces(content, opts.Namespaces)
+
+	// assert that there are no {{repl or repl{{ templates left.
+	// if there are, we need to fail the build
+	// files are converted concurrently, so results are printed by the caller in order
+	hasTemplateFunctions, err := numKotsTemplateFunctions(path, content, false)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to check for kots template functions")
+	}
+
+	if hasTemplateFunctions > 0 && opts.UnconvertedPolicy == UnconvertedPolicyComment {
+		content = commentUnconvertedTemplateFunctions(pathWithoutWorkspace, content)
+	}
+
+	if opts.Cache != nil {
+		conversion := cachedConversion{
+			Content:   content,
+			Remaining: hasTemplateFunctions,
+		}
+		if err := opts.Cache.put(cacheKey, conversion); err != nil {
+			return nil, 0, errors.Wrapf(err, "failed to write cache for %q", path)
+		}
+	}
+
+	return content, hasTemplateFunctions, nil
 }
 
 type HelmifyOpts struct {
 	FullExpandConfigOptionEqualsToIfElseEnd bool
+	// ChartName is used to name the templates in the generated _helpers.tpl
+	ChartName string
+	// Values are the keys of the config items in the values
+	Values ValuesOpts
 }
 
 func helmify(content []byte, kotsConfig *kotsv1beta1.Config, opts HelmifyOpts) ([]byte, error) {
@@ -113,7 +258,7 @@ func helmify(content []byte, kotsConfig *kotsv1beta1.Config, opts HelmifyOpts) (
 	// content will be updated and resaved at the end of the function
 
 	// ConfigOption
-	c, err := replaceConfigOption(content, kotsConfig)
+	c, err := replaceConfigOption(content, kotsConfig, opts.ChartName, opts.Values)
 	if err != nil {
 		return nil, err
 	}
@@ -124,7 +269,7 @@ func helmify(content []byte, kotsConfig *kotsv1beta1.Config, opts HelmifyOpts) (
 	// ConfigOptionFilename
 
 	// ConfigOptionEquals
-	c, err = replaceConfigOptionEquals(content, kotsConfig, opts.FullExpandConfigOptionEqualsToIfElseEnd)
+	c, err = replaceConfigOptionEquals(content, kotsConfig, opts.ChartName, opts.Values, opts.FullExpandConfigOptionEqualsToIfElseEnd)
 	if err != nil {
 		return nil, err
 	}
@@ -139,6 +284,20 @@ func helmify(content []byte, kotsConfig *kotsv1beta1.Config, opts HelmifyOpts) (
 	}
 	content = c
 
+	// Distribution, IsAirgap, KotsVersion, HTTPProxy, HTTPSProxy, NoProxy
+	c, err = replaceEnvironmentFunctions(content, opts.ChartName)
+	if err != nil {
+		return nil, err
+	}
+	content = c
+
+	// TLSCert, TLSKey, TLSCACert, TLSCAKey, TLSCertFromCA, TLSKeyFromCA
+	c, err = replaceTLSFunctions(content, opts.ChartName)
+	if err != nil {
+		return nil, err
+	}
+	content = c
+
 	// IsKurl
 	c, err = replaceIsKurl(content)
 	if err != nil {
@@ -146,6 +305,13 @@ func helmify(content []byte, kotsConfig *kotsv1beta1.Config, opts HelmifyOpts) (
 	}
 	content = c
 
+	// KurlBool, KurlInt, KurlString, KurlOption
+	c, err = replaceKurlFunctions(content)
+	if err != nil {
+		return nil, err
+	}
+	content = c
+
 	// if and conditional
 	c, err = replaceIfAndConditional(content)
 	if err != nil {
@@ -153,111 +319,132 @@ func helmify(content []byte, kotsConfig *kotsv1beta1.Config, opts HelmifyOpts) (
 	}
 	content = c
 
+	// the delimiters of the statements that are now helm templates
+	content = convertStatementDelimiters(content)
+
 	return content, nil
 }
 
-func replaceIfAndConditional(content []byte) ([]byte, error) {
-	delimiters := map[string]string{
-		`(?:{{repl\s+if)(?:\s?)`:   "{{ if ",
-		`(?:repl{{\s+if)(?:\s?)`:   "{{ if ",
-		`(?:{{repl\s+else)(?:\s?)`: "{{ else ",
-		`(?:repl{{\s+else)(?:\s?)`: "{{ else ",
-		`(?:{{repl\s+end)(?:\s?)`:  "{{ end ",
-		`(?:repl{{\s+end)(?:\s?)`:  "{{ end ",
-	}
+// templateTranslator is a compiled kots template pattern and the helm template that
+// replaces it. patterns are compiled once, not for every file
+type templateTranslator struct {
+	Delimiter *regexp.Regexp
+	Value     string
+}
 
+var ifAndConditionalTranslators = []templateTranslator{
+	{Delimiter: regexp.MustCompile(`(?:{{repl\s+if)(?:\s?)`), Value: "{{ if "},
+	{Delimiter: regexp.MustCompile(`(?:repl{{\s+if)(?:\s?)`), Value: "{{ if "},
+	{Delimiter: regexp.MustCompile(`(?:{{repl\s+else)(?:\s?)`), Value: "{{ else "},
+	{Delimiter: regexp.MustCompile(`(?:repl{{\s+else)(?:\s?)`), Value: "{{ else "},
+	{Delimiter: regexp.MustCompile(`(?:{{repl\s+end)(?:\s?)`), Value: "{{ end "},
+	{Delimiter: regexp.MustCompile(`(?:repl{{\s+end)(?:\s?)`), Value: "{{ end "},
+}
+
+func replaceIfAndConditional(content []byte) ([]byte, error) {
 	updatedContent := string(content)
 
-	for delimiter, replace := range delimiters {
-		r := regexp.MustCompile(delimiter)
-		regexMatch := r.FindAllStringSubmatch(string(content), -1)
+	for _, translator := range ifAndConditionalTranslators {
+		regexMatch := translator.Delimiter.FindAllStringSubmatch(string(content), -1)
 		for _, result := range regexMatch {
-			updatedContent = strings.ReplaceAll(updatedContent, result[0], replace)
+			updatedContent = strings.ReplaceAll(updatedContent, result[0], translator.Value)
 		}
 	}
 
 	return []byte(updatedContent), nil
 }
 
+var isKurlTranslators = []templateTranslator{
+	{Delimiter: regexp.MustCompile(`(?:{{repl\s+IsKurl)(?:\s?)`), Value: `{{ .Values.isKurl `},
+	{Delimiter: regexp.MustCompile(`(?:repl{{\s+IsKurl)(?:\s?)`), Value: `{{ .Values.isKurl `},
+	{Delimiter: regexp.MustCompile(`(?:{{repl\s+not\s+IsKurl)(?:\s?)`), Value: `{{ not .Values.isKurl `},
+	{Delimiter: regexp.MustCompile(`(?:repl{{\s+not\s+IsKurl)(?:\s?)`), Value: `{{ not .Values.isKurl `},
+}
+
 // replaceIsKurl IsKurl
 func replaceIsKurl(content []byte) ([]byte, error) {
-	delimiters := map[string]string{
-		`(?:{{repl\s+IsKurl)(?:\s?)`:       `{{ .Values.isKurl `,
-		`(?:repl{{\s+IsKurl)(?:\s?)`:       `{{ .Values.isKurl `,
-		`(?:{{repl\s+not\s+IsKurl)(?:\s?)`: `{{ not .Values.isKurl `,
-		`(?:repl{{\s+not\s+IsKurl)(?:\s?)`: `{{ not .Values.isKurl `,
-	}
-
 	updatedContent := string(content)
 
-	for delimiter, value := range delimiters {
-		r := regexp.MustCompile(delimiter)
-		regexMatch := r.FindAllStringSubmatch(string(content), -1)
+	for _, translator := range isKurlTranslators {
+		regexMatch := translator.Delimiter.FindAllStringSubmatch(string(content), -1)
 		for _, result := range regexMatch {
-			updatedContent = strings.ReplaceAll(updatedContent, result[0], value)
+			updatedContent = strings.ReplaceAll(updatedContent, result[0], translator.Value)
 		}
 	}
 
 	return []byte(updatedContent), nil
 }
 
-func replaceNamespace(content []byte) ([]byte, error) {
-	delimiters := []string{
-		`(?:{{repl\s+Namespace)(?:\s?}})`,
-		`(?:repl{{\s+Namespace)(?:\s?}})`,
-	}
+var namespaceTranslators = []templateTranslator{
+	{Delimiter: regexp.MustCompile(`(?:{{repl\s+Namespace)(?:\s?}})`), Value: `{{ .Release.Namespace }}`},
+	{Delimiter: regexp.MustCompile(`(?:repl{{\s+Namespace)(?:\s?}})`), Value: `{{ .Release.Namespace }}`},
+}
 
+func replaceNamespace(content []byte) ([]byte, error) {
 	updatedContent := string(content)
 
-	for _, delimiter := range delimiters {
-		r := regexp.MustCompile(delimiter)
-		regexMatch := r.FindAllStringSubmatch(string(content), -1)
+	for _, translator := range namespaceTranslators {
+		regexMatch := translator.Delimiter.FindAllStringSubmatch(string(content), -1)
 		for _, result := range regexMatch {
-			updatedContent = strings.ReplaceAll(updatedContent, result[0], `{{ .Release.Namespace }}`)
+			updatedContent = strings.ReplaceAll(updatedContent, result[0], translator.Value)
 		}
 	}
 
 	return []byte(updatedContent), nil
 }
 
-func replaceConfigOptionEquals(content []byte, kotsConfig *kotsv1beta1.Config, expandToElseEnd bool) ([]byte, error) {
-	// this is a supoer basic implementation for now
-	delimiters := []string{
-		`(?:{{repl\s+ConfigOptionEquals\s+\")(?P<Item>.*)(?:\"\s+\")(?P<Value>.*)(?:\"\s?}})`,
-		`(?:repl{{\s+ConfigOptionEquals\s+\")(?P<Item>.*)(?:\"\s+\")(?P<Value>.*)(?:\"\s?}})`,
-		// TODO " vs ' vs ` and more"
-	}
+// this is a super basic implementation for now
+var configOptionEqualsDelimiters = []*regexp.Regexp{
+	regexp.MustCompile(`(?:{{repl\s+ConfigOptionEquals\s+\")(?P<Item>.*)(?:\"\s+\")(?P<Value>.*)(?:\"\s?}})`),
+	regexp.MustCompile(`(?:repl{{\s+ConfigOptionEquals\s+\")(?P<Item>.*)(?:\"\s+\")(?P<Value>.*)(?:\"\s?}})`),
+	// TODO " vs ' vs ` and more"
+}
 
+func replaceConfigOptionEquals(content []byte, kotsConfig *kotsv1beta1.Config, chartName string, valuesOpts ValuesOpts, expandToElseEnd bool) ([]byte, error) {
 	updatedContent := string(content)
 
-	for _, delimiter := range delimiters {
-		r := regexp.MustCompile(delimiter)
+	for _, r := range configOptionEqualsDelimiters {
 		regexMatch := r.FindAllStringSubmatch(string(content), -1)
 		for _, result := range regexMatch {
-			valuesType, valuesPath, err := getValuesTypeAndPathForConfigItem(result[1], kotsConfig)
+			_, item, err := findConfigItem(result[1], kotsConfig)
 			if err != nil {
 				// we don't error here, it will catch it later if the function remains
 				// in the yaml
 				continue
 			}
+			reference, err := getValueReferenceForConfigItem(result[1], kotsConfig, chartName, valuesOpts)
+			if err != nil {
+				continue
+			}
+			reference = operand(reference)
 
 			// TODO this is not the only use of ConfigOptionEquals
-			switch valuesType {
-			case "string", "password", "":
-				if expandToElseEnd {
-					updatedContent = strings.ReplaceAll(updatedContent, result[0], fmt.Sprintf(`{{ if eq .Values.%s %q }}true{{ else }}false{{ end }}`, valuesPath, result[2]))
-				} else {
-					updatedContent = strings.ReplaceAll(updatedContent, result[0], fmt.Sprintf(`{{ if eq .Values.%s %q }}`, valuesPath, result[2]))
-				}
+			switch item.Type {
 			case "bool":
 				v, err := strconv.ParseBool(result[2])
 				if err != nil {
 					return nil, errors.Wrap(err, "failed to parse bool")
 				}
+				condition := fmt.Sprintf(`eq %s %t`, reference, v)
+				if valuesOpts.isTemplated(item) {
+					// rendered as a string, which is empty when the item is hidden
+					rendered := `"0" "false"`
+					if v {
+						rendered = `"1" "true"`
+					}
+					condition = fmt.Sprintf(`has %s (list %s)`, reference, rendered)
+				}
+				if expandToElseEnd {
+					updatedContent = strings.ReplaceAll(updatedContent, result[0], fmt.Sprintf(`{{ if %s }}true{{ else }}false{{ end }}`, condition))
+				} else {
+					updatedContent = strings.ReplaceAll(updatedContent, result[0], fmt.Sprintf(`{{ if %s }}`, condition))
+				}
+			default:
+				// every other type is a string in the values
 				if expandToElseEnd {
-					updatedContent = strings.ReplaceAll(updatedContent, result[0], fmt.Sprintf(`{{ if eq .Values.%s %t }}true{{ else }}false{{ end }}`, valuesPath, v))
+					updatedContent = strings.ReplaceAll(updatedContent, result[0], fmt.Sprintf(`{{ if eq %s %q }}true{{ else }}false{{ end }}`, reference, result[2]))
 				} else {
-					updatedContent = strings.ReplaceAll(updatedContent, result[0], fmt.Sprintf(`{{ if eq .Values.%s %t }}`, valuesPath, v))
+					updatedContent = strings.ReplaceAll(updatedContent, result[0], fmt.Sprintf(`{{ if eq %s %q }}`, reference, result[2]))
 				}
 			}
 
@@ -267,7 +454,7 @@ func replaceConfigOptionEquals(content []byte, kotsConfig *kotsv1beta1.Config, e
 	return []byte(updatedContent), nil
 }
 
-func replaceWhenAndExcludeAnnotations(content []byte, kotsConfig *kotsv1beta1.Config) ([]byte, error) {
+func replaceWhenAndExcludeAnnotations(content []byte, kotsConfig *kotsv1beta1.Config, opts HelmifyOpts) ([]byte, error) {
 	annotations, err := getAnnotations(content)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to get annotations")
@@ -276,9 +463,6 @@ func replaceWhenAndExcludeAnnotations(content []byte, kotsConfig *kotsv1beta1.Co
 	for k, v := range annotations {
 		if k == "kots.io/when" {
 			// convert the value to a helm template
-			opts := HelmifyOpts{
-				FullExpandConfigOptionEqualsToIfElseEnd: true,
-			}
 			helmed, err := helmify([]byte(v), kotsConfig, opts)
 			if err != nil {
 				return nil, errors.Wrap(err, "failed to helmify")
@@ -302,9 +486,6 @@ func replaceWhenAndExcludeAnnotations(content []byte, kotsConfig *kotsv1beta1.Co
 {{ end }}`, string(helmed), strings.TrimSpace(string(withoutWhen)))), nil
 		} else if k == "kots.io/exclude" {
 			// convert the value to a helm template
-			opts := HelmifyOpts{
-				FullExpandConfigOptionEqualsToIfElseEnd: true,
-			}
 			helmed, err := helmify([]byte(v), kotsConfig, opts)
 			if err != nil {
 				return nil, errors.Wrap(err, "failed to helmify")
@@ -331,70 +512,91 @@ func replaceWhenAndExcludeAnnotations(content []byte, kotsConfig *kotsv1beta1.Co
 	return content, nil
 }
 
-func replaceConfigOption(content []byte, kotsConfig *kotsv1beta1.Config) ([]byte, error) {
-	// this is a super basic implementation for now
-	type DelimiterValue struct {
-		Delimiter string
-		Value     string
-	}
-	delimiterValues := []DelimiterValue{
-		{
-			Delimiter: `(?:{{repl\s+ConfigOption\s+\")(?P<Item>.*)(?:\"\s?}})`,
-			Value:     `{{ .Values.%s }}`,
-		},
-		{
-			Delimiter: `(?:repl{{\s+ConfigOption\s+\")(?P<Item>.*)(?:\"\s?}})`,
-			Value:     `{{ .Values.%s }}`,
-		},
-		{
-			Delimiter: "(?:{{repl\\s+ConfigOption\\s+`)(?P<Item>.*)(?:`\\s?}})",
-			Value:     `{{ .Values.%s }}`,
-		},
-		{
-			Delimiter: "(?:repl{{\\s+ConfigOption\\s+`)(?P<Item>.*)(?:`\\s?}})",
-			Value:     `{{ .Values.%s }}`,
-		},
-		{
-			Delimiter: `(?:{{repl\s+ConfigOption\s+\")(?P<Item>.*)(?:\"\s?)`,
-			Value:     `{{ .Values.%s `,
-		},
-		{
-			Delimiter: `(?:repl{{\s+ConfigOption\s+\")(?P<Item>.*)(?:\"\s?)`,
-			Value:     `{{ .Values.%s `,
-		},
-		{
-			Delimiter: "(?:{{repl\\s+ConfigOption\\s+`)(?P<Item>.*)(?:`\\s?)",
-			Value:     `{{ .Values.%s `,
-		},
-		{
-			Delimiter: "(?:repl{{\\s+ConfigOption\\s+`)(?P<Item>.*)(?:`\\s?)",
-			Value:     `{{ .Values.%s `,
-		},
-		{
-			Delimiter: `(?:repl{{\s+ConfigOption\s+\")(?P<Item>([^\"]*))`,
-			Value:     `{{ ".Values.%s`, // this one is super hacky for now, because its' repl{{ , we assume it's a string and quote it
-		},
-		{
-			Delimiter: `(?:ConfigOption\s+\")(?P<Item>[^\s]+)(?:\")`,
-			Value:     `.Values.%s`,
-		},
-	}
+// configOptionTranslator is a compiled ConfigOption pattern and the format of the helm template
+// that replaces it. the format is given the reference to the config item's value
+type configOptionTranslator struct {
+	Delimiter *regexp.Regexp
+	Value     string
+	// Operand is set when the reference is an argument to another function, so it
+	// must be a single operand
+	Operand bool
+	// ValuesOnly is set when the reference must be a path in .Values
+	ValuesOnly bool
+}
 
+// this is a super basic implementation for now
+var configOptionTranslators = []configOptionTranslator{
+	{
+		Delimiter: regexp.MustCompile(`(?:{{repl\s+ConfigOption\s+\")(?P<Item>[^\"]*)(?:\"\s?}})`),
+		Value:     `{{ %s }}`,
+	},
+	{
+		Delimiter: regexp.MustCompile(`(?:repl{{\s+ConfigOption\s+\")(?P<Item>[^\"]*)(?:\"\s?}})`),
+		Value:     `{{ %s }}`,
+	},
+	{
+		Delimiter: regexp.MustCompile("(?:{{repl\\s+ConfigOption\\s+`)(?P<Item>[^`]*)(?:`\\s?}})"),
+		Value:     `{{ %s }}`,
+	},
+	{
+		Delimiter: regexp.MustCompile("(?:repl{{\\s+ConfigOption\\s+`)(?P<Item>[^`]*)(?:`\\s?}})"),
+		Value:     `{{ %s }}`,
+	},
+	{
+		Delimiter: regexp.MustCompile(`(?:{{repl\s+ConfigOption\s+\")(?P<Item>.*)(?:\"\s?)`),
+		Value:     `{{ %s `,
+	},
+	{
+		Delimiter: regexp.MustCompile(`(?:repl{{\s+ConfigOption\s+\")(?P<Item>.*)(?:\"\s?)`),
+		Value:     `{{ %s `,
+	},
+	{
+		Delimiter: regexp.MustCompile("(?:{{repl\\s+ConfigOption\\s+`)(?P<Item>.*)(?:`\\s?)"),
+		Value:     `{{ %s `,
+	},
+	{
+		Delimiter: regexp.MustCompile("(?:repl{{\\s+ConfigOption\\s+`)(?P<Item>.*)(?:`\\s?)"),
+		Value:     `{{ %s `,
+	},
+	{
+		Delimiter:  regexp.MustCompile(`(?:repl{{\s+ConfigOption\s+\")(?P<Item>([^\"]*))`),
+		Value:      `{{ "%s`, // this one is super hacky for now, because its' repl{{ , we assume it's a string and quote it
+		ValuesOnly: true,
+	},
+	{
+		Delimiter: regexp.MustCompile(`(?:ConfigOption\s+\")(?P<Item>[^\s]+)(?:\")`),
+		Value:     `%s`,
+		Operand:   true,
+	},
+}
+
+func replaceConfigOption(content []byte, kotsConfig *kotsv1beta1.Config, chartName string, valuesOpts ValuesOpts) ([]byte, error) {
 	updatedContent := string(content)
 
-	for _, dv := range delimiterValues {
-		r := regexp.MustCompile(dv.Delimiter)
-		regexMatch := r.FindAllStringSubmatch(string(updatedContent), -1)
+	for _, dv := range configOptionTranslators {
+		regexMatch := dv.Delimiter.FindAllStringSubmatch(string(updatedContent), -1)
 		for _, result := range regexMatch {
-			_, valuesPath, err := getValuesTypeAndPathForConfigItem(result[1], kotsConfig)
+			reference, err := getReferenceForConfigItem(result[1], kotsConfig, chartName, valuesOpts)
 			if err != nil {
 				// we don't error here, it will catch it later if the function remains
 				// in the yaml
 				continue
 			}
+			if dv.ValuesOnly {
+				path, ok := getValuesPathForConfigItem(result[1], kotsConfig, valuesOpts)
+				if !ok {
+					continue
+				}
+				reference = path
+			}
+			if dv.Operand {
+				// a reference that's already in parens doesn't need another set
+				updatedContent = strings.ReplaceAll(updatedContent, "("+result[0]+")", "("+reference+")")
+				reference = operand(reference)
+			}
 
-			updatedContent = strings.ReplaceAll(updatedContent, result[0], fmt.Sprintf(dv.Value, valuesPath))
-			logger.Verbosef("replaced %s with %s", result[0], fmt.Sprintf(dv.Value, valuesPath))
+			updatedContent = strings.ReplaceAll(updatedContent, result[0], fmt.Sprintf(dv.Value, reference))
+			logger.Verbosef("replaced %s with %s", result[0], fmt.Sprintf(dv.Value, reference))
 		}
 
 	}
@@ -402,26 +604,103 @@ func replaceConfigOption(content []byte, kotsConfig *kotsv1beta1.Config) ([]byte
 	return []byte(updatedContent), nil
 }
 
-func getValuesTypeAndPathForConfigItem(itemName string, kotsConfig *kotsv1beta1.Config) (string, string, error) {
+// findConfigItem returns the config item named itemName and the group it's in
+func findConfigItem(itemName string, kotsConfig *kotsv1beta1.Config) (kotsv1beta1.ConfigGroup, kotsv1beta1.ConfigItem, error) {
 	for _, group := range kotsConfig.Spec.Groups {
 		for _, item := range group.Items {
 			if item.Name == itemName {
-				return item.Type, fmt.Sprintf("%s.%s", group.Name, item.Name), nil
+				return group, item, nil
 			}
 		}
 	}
 
-	return "", "", errors.Errorf("failed to find config item %s", itemName)
+	return kotsv1beta1.ConfigGroup{}, kotsv1beta1.ConfigItem{}, errors.Errorf("failed to find config item %s", itemName)
+}
+
+// getReferenceForConfigItem returns the helm template expression that renders a config item
+// the same as ConfigOption does in kots. bools are typed in the values, so they're rendered
+// as "1" and "0"
+func getReferenceForConfigItem(itemName string, kotsConfig *kotsv1beta1.Config, chartName string, valuesOpts ValuesOpts) (string, error) {
+	_, item, err := findConfigItem(itemName, kotsConfig)
+	if err != nil {
+		return "", err
+	}
+	reference, err := getValueReferenceForConfigItem(itemName, kotsConfig, chartName, valuesOpts)
+	if err != nil {
+		return "", err
+	}
+
+	// the named template of a templated item renders bools as "1" and "0" itself
+	if item.Type == "bool" && !valuesOpts.isTemplated(item) {
+		return fmt.Sprintf(`ternary "1" "0" %s`, operand(reference)), nil
+	}
+
+	return reference, nil
 }
 
+// getValueReferenceForConfigItem returns the helm template expression for the typed value of
+// a config item. most items are read from .Values, items with a generated, derived or
+// conditional value use the named template in _helpers.tpl, which renders bools as strings.
+// required and regex validated items are guarded where they're used, except for conditional
+// items, which are guarded in their named template so that they're only checked when shown
+func getValueReferenceForConfigItem(itemName string, kotsConfig *kotsv1beta1.Config, chartName string, valuesOpts ValuesOpts) (string, error) {
+	group, item, err := findConfigItem(itemName, kotsConfig)
+	if err != nil {
+		return "", err
+	}
+
+	if isRandomConfigItem(group, item) || valuesOpts.Conditional[item.Name] {
+		return configItemInclude(chartName, item), nil
+	}
+	if valuesOpts.Derived[item.Name] {
+		return guardConfigItemValue(chartName, item, valuesOpts, configItemInclude(chartName, item)), nil
+	}
+
+	return guardConfigItemValue(chartName, item, valuesOpts, configItemValuesReference(group, item, valuesOpts)), nil
+}
+
+// getValuesPathForConfigItem returns the .Values path of a config item that's read straight
+// from the values without a guard, for the translator that quotes the path
+func getValuesPathForConfigItem(itemName string, kotsConfig *kotsv1beta1.Config, valuesOpts ValuesOpts) (string, bool) {
+	group, item, err := findConfigItem(itemName, kotsConfig)
+	if err != nil || item.Type == "bool" || isRandomConfigItem(group, item) || valuesOpts.isTemplated(item) {
+		return "", false
+	}
+	if item.Required || valuesOpts.Validations[item.Name] != (ConfigItemValidation{}) {
+		return "", false
+	}
+
+	keys := valuesOpts.configItemKeys(group, item)
+	for _, key := range keys {
+		if !templateIdentifierRegex.MatchString(key) {
+			return "", false
+		}
+	}
+
+	return ".Values." + strings.Join(keys, "."), true
+}
+
+// operand wraps a template expression in parens when it's more than a single operand
+func operand(expr string) string {
+	if strings.ContainsAny(expr, " \t") {
+		return "(" + expr + ")"
+	}
+	return expr
+}
+
+var (
+	replPrefixRegex = regexp.MustCompile(`{{repl\s+`)
+	replSuffixRegex = regexp.MustCompile(`repl{{\s+`)
+)
+
 func numKotsTemplateFunctions(filename string, content []byte, printResults bool) (int, error) {
 	numReplFns := 0
 
 	// {{repl
-	numReplFns += len(regexp.MustCompile(`{{repl\s+`).FindAllString(string(content), -1))
+	numReplFns += len(replPrefixRegex.FindAllString(string(content), -1))
 
 	// repl{{
-	numReplFns += len(regexp.MustCompile(`repl{{\s+`).FindAllString(string(content), -1))
+	numReplFns += len(replSuffixRegex.FindAllString(string(content), -1))
 
 	if numReplFns > 0 && printResults {
 		fmt.Printf("file %s has %d unconverted kots functions\n", filename, numReplFns)
@@ -1,6 +1,10 @@
 package builder
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
@@ -134,7 +138,7 @@ func Test_replaceConfigOption(t *testing.T) {
 					},
 				},
 			},
-			expect: `name: "{{ .Values.group1.foo1 }}"`,
+			expect: `name: "{{ dig "group1" "foo1" "" .Values.AsMap }}"`,
 		},
 		{
 			name: "direct replace with ` as quotes",
@@ -156,7 +160,7 @@ func Test_replaceConfigOption(t *testing.T) {
 					},
 				},
 			},
-			expect: `name: {{ .Values.group1.foo }}`,
+			expect: `name: {{ dig "group1" "foo" "" .Values.AsMap }}`,
 		},
 		{
 			name: "direct replace with reverse template",
@@ -178,7 +182,7 @@ func Test_replaceConfigOption(t *testing.T) {
 					},
 				},
 			},
-			expect: `name: "{{ .Values.group1.foo }}"`,
+			expect: `name: "{{ dig "group1" "foo" "" .Values.AsMap }}"`,
 		},
 		{
 			name: "ignoring ConfigOptionEquals",
@@ -244,7 +248,7 @@ func Test_replaceConfigOption(t *testing.T) {
 					},
 				},
 			},
-			expect: `password: '{{ .Values.group1.postgres_password | Base64Encode }}'`,
+			expect: `password: '{{ dig "group1" "postgres_password" "" .Values.AsMap | Base64Encode }}'`,
 		},
 		{
 			name: "complex item",
@@ -266,7 +270,7 @@ func Test_replaceConfigOption(t *testing.T) {
 					},
 				},
 			},
-			expect: `password: '{{ if eq (.Values.group1.something) "1"}}true{{ else }}false{{ end }}'`,
+			expect: `password: '{{ if eq (dig "group1" "something" "" .Values.AsMap) "1"}}true{{ else }}false{{ end }}'`,
 		},
 		{
 			name: "no quotes",
@@ -290,11 +294,113 @@ func Test_replaceConfigOption(t *testing.T) {
 			},
 			expect: `item: {{ ".Values.group1.val" | splitList "." | first  }}`,
 		},
+		{
+			name: "two items on a line",
+			args: args{
+				content: `url: https://repl{{ ConfigOption "host" }}:repl{{ ConfigOption "port" }}`,
+				kotsConfig: &kotsv1beta1.Config{
+					Spec: kotsv1beta1.ConfigSpec{
+						Groups: []kotsv1beta1.ConfigGroup{
+							{
+								Name: "group1",
+								Items: []kotsv1beta1.ConfigItem{
+									{
+										Name: "host",
+										Type: "text",
+									},
+									{
+										Name: "port",
+										Type: "text",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			expect: `url: https://{{ dig "group1" "host" "" .Values.AsMap }}:{{ dig "group1" "port" "" .Values.AsMap }}`,
+		},
+		{
+			name: "random value",
+			args: args{
+				content: `password: repl{{ ConfigOption "db_password" }}
+url: postgres://app:repl{{ ConfigOption "db_password" | urlquery }}@postgres
+enabled: '{{repl printf "%s" (ConfigOption "db_password") }}'`,
+				kotsConfig: &kotsv1beta1.Config{
+					Spec: kotsv1beta1.ConfigSpec{
+						Groups: []kotsv1beta1.ConfigGroup{
+							{
+								Name: "database",
+								Items: []kotsv1beta1.ConfigItem{
+									{
+										Name:  "db_password",
+										Type:  "password",
+										Value: multitype.FromString(`repl{{ RandomString 32 }}`),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			expect: `password: {{ include "test.config.db_password" $ }}
+url: postgres://app:{{ include "test.config.db_password" $ | urlquery }}@postgres
+enabled: '{{repl printf "%s" (include "test.config.db_password" $) }}'`,
+		},
+		{
+			name: "bool value",
+			args: args{
+				content: `enabled: '{{repl ConfigOption "tls_enabled" }}'
+tls: '{{repl if eq (ConfigOption "tls_enabled") "1" }}yes{{repl end }}'`,
+				kotsConfig: &kotsv1beta1.Config{
+					Spec: kotsv1beta1.ConfigSpec{
+						Groups: []kotsv1beta1.ConfigGroup{
+							{
+								Name: "group1",
+								Items: []kotsv1beta1.ConfigItem{
+									{
+										Name:    "tls_enabled",
+										Type:    "bool",
+										Default: multitype.FromString("1"),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			expect: `enabled: '{{ ternary "1" "0" (dig "group1" "tls_enabled" true .Values.AsMap) }}'
+tls: '{{repl if eq (ternary "1" "0" (dig "group1" "tls_enabled" true .Values.AsMap)) "1" }}yes{{repl end }}'`,
+		},
+		{
+			name: "dashed names",
+			args: args{
+				content: `host: repl{{ ConfigOption "db-host" }}
+url: postgres://repl{{ ConfigOption "db-host" | lower }}:5432`,
+				kotsConfig: &kotsv1beta1.Config{
+					Spec: kotsv1beta1.ConfigSpec{
+						Groups: []kotsv1beta1.ConfigGroup{
+							{
+								Name: "my-group",
+								Items: []kotsv1beta1.ConfigItem{
+									{
+										Name: "db-host",
+										Type: "text",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+			expect: `host: {{ dig "my-group" "db-host" "" .Values.AsMap }}
+url: postgres://{{ dig "my-group" "db-host" "" .Values.AsMap | lower }}:5432`,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			req := require.New(t)
-			actual, err := replaceConfigOption([]byte(tt.args.content), tt.args.kotsConfig)
+			actual, err := replaceConfigOption([]byte(tt.args.content), tt.args.kotsConfig, "test", ValuesOpts{})
 			req.NoError(err)
 			assert.Equal(t, tt.expect, string(actual))
 		})
@@ -344,7 +450,7 @@ func Test_replaceConfigOptionEquals(t *testing.T) {
 					},
 				},
 			},
-			expect: `name: "{{ if eq .Values.group1.foo "bar" }}true{{ else }}false{{ end }}"`,
+			expect: `name: "{{ if eq (dig "group1" "foo" "" .Values.AsMap) "bar" }}true{{ else }}false{{ end }}"`,
 		},
 		{
 			name: "direct replace, bool type 1",
@@ -367,7 +473,7 @@ func Test_replaceConfigOptionEquals(t *testing.T) {
 					},
 				},
 			},
-			expect: `name: "{{ if eq .Values.group1.foo true }}true{{ else }}false{{ end }}"`,
+			expect: `name: "{{ if eq (dig "group1" "foo" false .Values.AsMap) true }}true{{ else }}false{{ end }}"`,
 		},
 		{
 			name: "direct replace, bool type 0",
@@ -390,7 +496,7 @@ func Test_replaceConfigOptionEquals(t *testing.T) {
 					},
 				},
 			},
-			expect: `name: "{{ if eq .Values.group1.foo false }}true{{ else }}false{{ end }}"`,
+			expect: `name: "{{ if eq (dig "group1" "foo" false .Values.AsMap) false }}true{{ else }}false{{ end }}"`,
 		},
 		{
 			name: "quoted",
@@ -412,13 +518,13 @@ func Test_replaceConfigOptionEquals(t *testing.T) {
 					},
 				},
 			},
-			expect: `'{{ if eq .Values.group1.redis_type "embedded_redis" }}true{{ else }}false{{ end }}'`,
+			expect: `'{{ if eq (dig "group1" "redis_type" "" .Values.AsMap) "embedded_redis" }}true{{ else }}false{{ end }}'`,
 		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			req := require.New(t)
-			actual, err := replaceConfigOptionEquals([]byte(tt.args.content), tt.args.kotsConfig, true)
+			actual, err := replaceConfigOptionEquals([]byte(tt.args.content), tt.args.kotsConfig, "test", ValuesOpts{}, true)
 			req.NoError(err)
 			assert.Equal(t, tt.expect, string(actual))
 		})
@@ -593,3 +699,56 @@ func Test_replaceIfAndConditional(t *testing.T) {
 // 		})
 // 	}
 // }
+
+func Test_replaceKOTSTemplatesWithHelmTemplates(t *testing.T) {
+	req := require.New(t)
+
+	workspace := t.TempDir()
+	templates := filepath.Join(workspace, "templates")
+	req.NoError(os.MkdirAll(templates, 0755))
+
+	files := map[string]string{
+		"config.yaml": `apiVersion: kots.io/v1beta1
+kind: Config
+metadata:
+  name: config
+spec:
+  groups:
+    - name: group1
+      items:
+        - name: foo
+          type: text`,
+	}
+	expect := map[string]string{}
+	expectRemaining := map[string]int{}
+	for i := 0; i < 50; i++ {
+		name := fmt.Sprintf("configmap-%02d.yaml", i)
+		files[name] = fmt.Sprintf("name: cm-%d\nfoo: repl{{ ConfigOption \"foo\" }}\nns: repl{{ Namespace }}", i)
+		expect[name] = fmt.Sprintf("name: cm-%d\nfoo: {{ include \"test.yamlScalar\" (dig \"group1\" \"foo\" \"\" .Values.AsMap) }}\nns: {{ .Release.Namespace }}", i)
+		if i%10 == 0 {
+			files[name] += "\nlicense: repl{{ LicenseFieldValue \"id\" }}"
+			expect[name] += "\nlicense: repl{{ LicenseFieldValue \"id\" }}"
+			expectRemaining[filepath.Join("templates", name)] = 1
+		}
+	}
+	for name, content := range files {
+		req.NoError(ioutil.WriteFile(filepath.Join(templates, name), []byte(content), 0644))
+	}
+
+	index, err := indexWorkspace(workspace)
+	req.NoError(err)
+
+	remaining, err := replaceKOTSTemplatesWithHelmTemplates(workspace, index, conversionOpts{
+		UnconvertedPolicy: UnconvertedPolicyKeep,
+		ChartName:         "test",
+		Concurrency:       8,
+	})
+	req.NoError(err)
+	assert.Equal(t, expectRemaining, remaining)
+
+	for name, content := range expect {
+		actual, err := ioutil.ReadFile(filepath.Join(templates, name))
+		req.NoError(err)
+		assert.Equal(t, content, string(actual), name)
+	}
+}
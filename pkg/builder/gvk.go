@@ -2,6 +2,7 @@ package builder
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/pkg/errors"
@@ -12,6 +13,8 @@ const (
 	KOTSConfigGVK = "kots.io/v1beta1/Config"
 )
 
+var yamlDocumentSeparatorRegex = regexp.MustCompile(`(?m)^---\s*$`)
+
 type OverlySimpleGVK struct {
 	APIVersion string `yaml:"apiVersion"`
 	Kind       string `yaml:"kind"`
@@ -43,6 +46,9 @@ func isKOTSManifest(content []byte) (bool, error) {
 	if strings.HasPrefix(o.APIVersion, "troubleshoot.sh") {
 		return true, nil
 	}
+	if strings.HasPrefix(o.APIVersion, "cluster.kurl.sh") || strings.HasPrefix(o.APIVersion, "kurl.sh") {
+		return true, nil
+	}
 	if o.APIVersion == "app.k8s.io/v1beta1" && o.Kind == "Application" {
 		return true, nil
 	}
@@ -88,3 +94,14 @@ func withAnnotations(content []byte, annotations map[string]string) ([]byte, err
 
 	return remarshaled, nil
 }
+
+func isEmptyYAMLDocument(doc string) bool {
+	for _, line := range strings.Split(doc, "\n") {
+		trimmed := strings.TrimSpace(line)
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			return false
+		}
+	}
+
+	return true
+}
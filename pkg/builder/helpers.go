@@ -0,0 +1,514 @@
+package builder
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"text/template"
+
+	"github.com/pkg/errors"
+)
+
+// helpersTemplate is rendered to templates/_helpers.tpl in the chart. it uses [[ ]]
+// delimiters so the helm templates it contains don't need to be escaped
+var helpersTemplate = template.Must(template.New("_helpers.tpl").Delims("[[", "]]").Parse(`{{/*
+Expand the name of the chart.
+*/}}
+{{- define "[[ .Name ]].name" -}}
+{{- default .Chart.Name .Values.nameOverride | trunc 63 | trimSuffix "-" -}}
+{{- end -}}
+
+{{/*
+Create a default fully qualified app name, truncated at 63 chars because some
+kubernetes name fields are limited to this. If the release name contains the chart
+name it will be used as the full name.
+*/}}
+{{- define "[[ .Name ]].fullname" -}}
+{{- if .Values.fullnameOverride -}}
+{{- .Values.fullnameOverride | trunc 63 | trimSuffix "-" -}}
+{{- else -}}
+{{- $name := default .Chart.Name .Values.nameOverride -}}
+{{- if contains $name .Release.Name -}}
+{{- .Release.Name | trunc 63 | trimSuffix "-" -}}
+{{- else -}}
+{{- printf "%s-%s" .Release.Name $name | trunc 63 | trimSuffix "-" -}}
+{{- end -}}
+{{- end -}}
+{{- end -}}
+
+{{/*
+Prefixes a resource name with the fullname. The argument is a list of the root context
+and the name.
+*/}}
+{{- define "[[ .Name ]].prefixedName" -}}
+{{- printf "%s-%s" (include "[[ .Name ]].fullname" (index . 0)) (index . 1) | trunc 63 | trimSuffix "-" -}}
+{{- end -}}
+
+{{/*
+Renders a config value as a yaml plain scalar, quoting it when it's empty, spans lines or
+would change the structure of the document.
+*/}}
+{{- define "[[ .Name ]].yamlScalar" -}}
+{{- $value := toString . -}}
+{{- if or (contains ": " $value) (contains " #" $value) (hasSuffix ":" $value) (ne $value (trim $value)) (not (regexMatch "^(?:[^-?:,\\[\\]{}#&*!|>'\"%@\\x60\\s]|[-?:]\\S)[^\\n]*$" $value)) -}}
+{{- quote $value -}}
+{{- else -}}
+{{- $value -}}
+{{- end -}}
+{{- end -}}
+
+{{/*
+Create chart name and version as used by the chart label.
+*/}}
+{{- define "[[ .Name ]].chart" -}}
+{{- printf "%s-%s" .Chart.Name .Chart.Version | replace "+" "_" | trunc 63 | trimSuffix "-" -}}
+{{- end -}}
+
+{{/*
+Common labels, these are added to the metadata of every converted manifest.
+*/}}
+{{- define "[[ .Name ]].labels" -}}
+helm.sh/chart: {{ include "[[ .Name ]].chart" . }}
+{{ include "[[ .Name ]].selectorLabels" . }}
+{{- if .Chart.AppVersion }}
+app.kubernetes.io/version: {{ .Chart.AppVersion | quote }}
+{{- end }}
+app.kubernetes.io/managed-by: {{ .Release.Service }}
+{{- end -}}
+
+{{/*
+The common labels without the labels a manifest already has. The argument is a list of the
+root context and the keys to leave out.
+*/}}
+{{- define "[[ .Name ]].labelsWithout" -}}
+{{- $labels := include "[[ .Name ]].labels" (index . 0) | fromYaml -}}
+{{- range rest . -}}
+{{- $_ := unset $labels . -}}
+{{- end -}}
+{{- if $labels -}}
+{{- toYaml $labels -}}
+{{- end -}}
+{{- end -}}
+
+{{/*
+Selector labels.
+*/}}
+{{- define "[[ .Name ]].selectorLabels" -}}
+app.kubernetes.io/name: {{ include "[[ .Name ]].name" . }}
+app.kubernetes.io/instance: {{ .Release.Name }}
+{{- end -}}
+
+{{/*
+The kubernetes distribution the chart is installed to, this replaces the kots
+Distribution function. Set .Values.distribution to override the detection.
+*/}}
+{{- define "[[ .Name ]].distribution" -}}
+{{- if .Values.distribution -}}
+{{- .Values.distribution -}}
+{{- else if .Capabilities.APIVersions.Has "route.openshift.io/v1" -}}
+openShift
+{{- else if .Capabilities.APIVersions.Has "cluster.kurl.sh/v1beta1" -}}
+kurl
+{{- else if .Capabilities.APIVersions.Has "run.tanzu.vmware.com/v1alpha1" -}}
+tanzu
+{{- else if contains "-gke." .Capabilities.KubeVersion.Version -}}
+gke
+{{- else if contains "-eks-" .Capabilities.KubeVersion.Version -}}
+eks
+{{- else if contains "+k3s" .Capabilities.KubeVersion.Version -}}
+k3s
+{{- else if contains "+rke2" .Capabilities.KubeVersion.Version -}}
+rke2
+{{- end -}}
+{{- end -}}
+
+{{/*
+The name of the Secret that stores the material generated for the kots TLS functions.
+*/}}
+{{- define "[[ .Name ]].tls.secretName" -}}
+{{- printf "%s-kots2helm-tls" .Release.Name | trunc 63 | trimSuffix "-" -}}
+{{- end -}}
+
+{{/*
+Generates the material for a kots TLS function once per name for each render. The
+argument is a list of the root context, the kind (cert, ca or certFromCA), the CA name,
+the cert name and then the optional common name, ips, alternate dns names and days
+valid. Material from an earlier install is read back from the tls Secret so that
+certificates don't rotate with every upgrade.
+*/}}
+{{- define "[[ .Name ]].tls.generate" -}}
+{{- $root := index . 0 -}}
+{{- $kind := index . 1 -}}
+{{- $caName := index . 2 -}}
+{{- $certName := index . 3 -}}
+{{- $args := slice . 4 -}}
+{{- if not (hasKey $root "kots2helmTLS") -}}
+{{- $_ := set $root "kots2helmTLS" dict -}}
+{{- end -}}
+{{- $cache := get $root "kots2helmTLS" -}}
+{{- $key := $certName -}}
+{{- if eq $kind "ca" -}}
+{{- $key = printf "%s.ca" $caName -}}
+{{- end -}}
+{{- if not (hasKey $cache $key) -}}
+{{- $existing := dict -}}
+{{- $secret := lookup "v1" "Secret" $root.Release.Namespace (include "[[ .Name ]].tls.secretName" $root) -}}
+{{- if $secret -}}
+{{- $existing = $secret.data | default dict -}}
+{{- end -}}
+{{- if hasKey $existing (printf "%s.crt" $key) -}}
+{{- $_ := set $cache $key (dict "cert" (get $existing (printf "%s.crt" $key) | b64dec) "key" (get $existing (printf "%s.key" $key) | b64dec)) -}}
+{{- else if eq $kind "ca" -}}
+{{- $days := 365 -}}
+{{- if ge (len $args) 1 -}}
+{{- $days = index $args 0 | int -}}
+{{- end -}}
+{{- $ca := genCA $caName $days -}}
+{{- $_ := set $cache $key (dict "cert" $ca.Cert "key" $ca.Key) -}}
+{{- else -}}
+{{- $cn := $certName -}}
+{{- $ips := list -}}
+{{- $alternateDNS := list -}}
+{{- $days := 365 -}}
+{{- if ge (len $args) 1 -}}
+{{- $cn = index $args 0 -}}
+{{- end -}}
+{{- if ge (len $args) 2 -}}
+{{- $ips = index $args 1 -}}
+{{- end -}}
+{{- if ge (len $args) 3 -}}
+{{- $alternateDNS = index $args 2 -}}
+{{- end -}}
+{{- if ge (len $args) 4 -}}
+{{- $days = index $args 3 | int -}}
+{{- end -}}
+{{- if eq $kind "certFromCA" -}}
+{{- $_ := include "[[ .Name ]].tls.generate" (list $root "ca" $caName "") -}}
+{{- $caMaterial := get $cache (printf "%s.ca" $caName) -}}
+{{- $ca := buildCustomCert ($caMaterial.cert | b64enc) ($caMaterial.key | b64enc) -}}
+{{- $cert := genSignedCert $cn $ips $alternateDNS $days $ca -}}
+{{- $_ := set $cache $key (dict "cert" $cert.Cert "key" $cert.Key) -}}
+{{- else -}}
+{{- $cert := genSelfSignedCert $cn $ips $alternateDNS $days -}}
+{{- $_ := set $cache $key (dict "cert" $cert.Cert "key" $cert.Key) -}}
+{{- end -}}
+{{- end -}}
+{{- end -}}
+{{- end -}}
+
+{{/*
+The certificate for TLSCert and TLSCertFromCA.
+*/}}
+{{- define "[[ .Name ]].tlsCert" -}}
+{{- $_ := include "[[ .Name ]].tls.generate" . -}}
+{{- (get (get (index . 0) "kots2helmTLS") (index . 3)).cert -}}
+{{- end -}}
+
+{{/*
+The private key for TLSKey and TLSKeyFromCA.
+*/}}
+{{- define "[[ .Name ]].tlsKey" -}}
+{{- $_ := include "[[ .Name ]].tls.generate" . -}}
+{{- (get (get (index . 0) "kots2helmTLS") (index . 3)).key -}}
+{{- end -}}
+
+{{/*
+The CA certificate for TLSCACert.
+*/}}
+{{- define "[[ .Name ]].tlsCACert" -}}
+{{- $_ := include "[[ .Name ]].tls.generate" . -}}
+{{- (get (get (index . 0) "kots2helmTLS") (printf "%s.ca" (index . 2))).cert -}}
+{{- end -}}
+
+{{/*
+The CA private key for TLSCAKey.
+*/}}
+{{- define "[[ .Name ]].tlsCAKey" -}}
+{{- $_ := include "[[ .Name ]].tls.generate" . -}}
+{{- (get (get (index . 0) "kots2helmTLS") (printf "%s.ca" (index . 2))).key -}}
+{{- end -}}
+[[- if .RandomItems ]]
+
+{{/*
+The name of the Secret that stores the values generated for RandomString and RandomBytes.
+*/}}
+{{- define "[[ .Name ]].random.secretName" -}}
+{{- printf "%s-kots2helm-random" .Release.Name | trunc 63 | trimSuffix "-" -}}
+{{- end -}}
+
+{{/*
+Generates the value of a random config item once for each render. The argument is a list
+of the root context, the item name, the value from values.yaml, the generator (string or
+bytes), the length and the regex that the characters of a string must match, which is
+alphanumeric when empty. A value set in values.yaml is always used, otherwise the value
+from an earlier install is read back from the random Secret so that it doesn't change with
+every upgrade.
+*/}}
+{{- define "[[ .Name ]].random.value" -}}
+{{- $root := index . 0 -}}
+{{- $item := index . 1 -}}
+{{- $value := index . 2 -}}
+{{- $generator := index . 3 -}}
+{{- $length := index . 4 | int -}}
+{{- $charset := index . 5 -}}
+{{- if not (hasKey $root "kots2helmRandom") -}}
+{{- $_ := set $root "kots2helmRandom" dict -}}
+{{- end -}}
+{{- $cache := get $root "kots2helmRandom" -}}
+{{- if not (hasKey $cache $item) -}}
+{{- $existing := dict -}}
+{{- $secret := lookup "v1" "Secret" $root.Release.Namespace (include "[[ .Name ]].random.secretName" $root) -}}
+{{- if $secret -}}
+{{- $existing = $secret.data | default dict -}}
+{{- end -}}
+{{- if $value -}}
+{{- $_ := set $cache $item (toString $value) -}}
+{{- else if hasKey $existing $item -}}
+{{- $_ := set $cache $item (get $existing $item | b64dec) -}}
+{{- else if eq $generator "bytes" -}}
+{{- $_ := set $cache $item (randBytes $length) -}}
+{{- else if $charset -}}
+{{- $chars := "" -}}
+{{- range until 10 -}}
+{{- if lt (len $chars) $length -}}
+{{- $chars = printf "%s%s" $chars (regexFindAll $charset (randAscii (mul $length 16 | int)) -1 | join "") -}}
+{{- end -}}
+{{- end -}}
+{{- $_ := set $cache $item (trunc $length $chars) -}}
+{{- else -}}
+{{- $_ := set $cache $item (randAlphaNum $length) -}}
+{{- end -}}
+{{- end -}}
+{{- get $cache $item -}}
+{{- end -}}
+[[- range .RandomItems ]]
+
+{{/*
+The value of the [[ .Name ]] config item.[[ if .When ]] It's empty when the item is hidden, the
+generated value is still stored.[[ end ]]
+*/}}
+{{- define "[[ $.Name ]].config.[[ .Name ]]" -}}
+[[- if .When ]]
+{{- if [[ .When ]] -}}
+[[- end ]]
+{{- include "[[ $.Name ]].random.value" (list . "[[ .Name ]]" [[ .ValuesReference ]] "[[ .Generator ]]" [[ .Length ]] [[ printf "%q" .Charset ]]) -}}
+[[- if .When ]]
+{{- end -}}
+[[- end ]]
+{{- end -}}
+[[- end ]]
+[[- end ]]
+[[- range .DerivedItems ]]
+
+{{/*
+The value of the [[ .Name ]] config item. When it isn't set, it's derived from the other
+config items the same as its [[ .Source ]] is in kots.[[ if .When ]] It's empty when the item is hidden.[[ end ]]
+*/}}
+{{- define "[[ $.Name ]].config.[[ .Name ]]" -}}
+[[- if .When ]]
+{{- if [[ .When ]] -}}
+[[- end ]]
+{{- $value := [[ .ValuesReference ]] -}}
+[[- if .Bool ]]
+{{- if kindIs "bool" $value -}}
+{{- ternary "1" "0" $value -}}
+[[- else ]]
+{{- if $value -}}
+{{- $value -}}
+[[- end ]]
+{{- else -}}
+[[ .Expression ]]
+{{- end -}}
+[[- if .When ]]
+{{- end -}}
+[[- end ]]
+{{- end -}}
+[[- end ]]
+[[- if .Whens ]]
+
+{{/*
+Renders true when a kots when expression is true. The argument is a list of the root context
+and the named template that renders the expression. kots hides a config group or item when
+its when is a false value, and the values of hidden items are empty.
+*/}}
+{{- define "[[ .Name ]].when" -}}
+{{- $when := include (index . 1) (index . 0) | trim | lower -}}
+{{- if not (has $when (list [[ range $i, $v := .WhenFalseValues ]][[ if $i ]] [[ end ]]"[[ $v ]]"[[ end ]])) -}}
+true
+{{- end -}}
+{{- end -}}
+[[- range .Whens ]]
+
+{{/*
+The when of [[ .Description ]].
+*/}}
+{{- define "[[ .Template ]]" -}}
+[[ .Expression ]]
+{{- end -}}
+[[- end ]]
+[[- end ]]
+[[- range .ConditionalItems ]]
+
+{{/*
+The value of the [[ .Name ]] config item, empty when it's hidden.
+*/}}
+{{- define "[[ $.Name ]].config.[[ .Name ]]" -}}
+{{- if [[ .When ]] -}}
+[[- if .Bool ]]
+{{- ternary "1" "0" [[ .ValuesReference ]] -}}
+[[- else ]]
+{{- [[ .ValuesReference ]] -}}
+[[- end ]]
+{{- end -}}
+{{- end -}}
+[[- end ]]
+[[- if .Validations ]]
+
+{{/*
+Checks the value of a config item against the regex validation of the item in kots, and
+renders the value when it matches. The argument is a list of the item title, the value, the
+pattern and the validation message. An empty value isn't checked, the same as in kots.
+*/}}
+{{- define "[[ .Name ]].validate" -}}
+{{- $value := index . 1 | default "" | toString -}}
+{{- if and $value (not (regexMatch (index . 2) $value)) -}}
+{{- fail (printf "%s: %s" (index . 0) (index . 3)) -}}
+{{- end -}}
+{{- $value -}}
+{{- end -}}
+[[- end ]]
+[[- if .PasswordItems ]]
+
+{{/*
+The name of the Secret that stores the values of the password config items.
+*/}}
+{{- define "[[ .Name ]].passwords.generatedSecretName" -}}
+{{- printf "%s-kots2helm-passwords" .Release.Name | trunc 63 | trimSuffix "-" -}}
+{{- end -}}
+
+{{/*
+The name of the Secret that holds a password config item. The argument is a list of the
+root context, the item name and its key in the passwords values. It's the existingSecret of
+the item when it's set, otherwise the generated passwords Secret.
+*/}}
+{{- define "[[ .Name ]].passwords.secretName" -}}
+{{- $root := index . 0 -}}
+{{- $existing := dig "passwords" (index . 2) "existingSecret" "" $root.Values.AsMap -}}
+{{- if $existing -}}
+{{- $existing -}}
+{{- else -}}
+{{- include "[[ .Name ]].passwords.generatedSecretName" $root -}}
+{{- end -}}
+{{- end -}}
+
+{{/*
+The key of a password config item in its Secret, with the same argument as secretName. It's
+the existingSecretKey of the item when it has an existingSecret, otherwise the item name.
+*/}}
+{{- define "[[ .Name ]].passwords.secretKey" -}}
+{{- $root := index . 0 -}}
+{{- $existing := dig "passwords" (index . 2) "existingSecret" "" $root.Values.AsMap -}}
+{{- $existingKey := dig "passwords" (index . 2) "existingSecretKey" "" $root.Values.AsMap -}}
+{{- if and $existing $existingKey -}}
+{{- $existingKey -}}
+{{- else -}}
+{{- index . 1 -}}
+{{- end -}}
+{{- end -}}
+
+{{/*
+Renders a required password config item and fails when it's empty, unless the item has an
+existingSecret. The argument is a list of the root context, the item's key in the passwords
+values, the message and the value.
+*/}}
+{{- define "[[ .Name ]].passwords.required" -}}
+{{- $root := index . 0 -}}
+{{- if dig "passwords" (index . 1) "existingSecret" "" $root.Values.AsMap -}}
+{{- index . 3 -}}
+{{- else -}}
+{{- required (index . 2) (index . 3) -}}
+{{- end -}}
+{{- end -}}
+[[- end ]]
+`))
+
+// createHelpersTPL will create templates/_helpers.tpl in workspace with the named
+// templates that converted manifests include
+func createHelpersTPL(workspace string, name string, index *workspaceIndex, valuesOpts ValuesOpts) error {
+	fileName := filepath.Join(workspace, "templates", "_helpers.tpl")
+
+	if _, err := os.Stat(fileName); err == nil {
+		return errors.New("templates/_helpers.tpl already exists in the input dir")
+	}
+
+	kotsConfig, err := getKOTSConfig(index)
+	if err != nil {
+		return err
+	}
+	randomItems := []randomConfigItem{}
+	derivedItems := []derivedConfigItem{}
+	conditionalItems := []conditionalConfigItem{}
+	whens := []configWhen{}
+	hasPasswordItems := false
+	if kotsConfig != nil {
+		randomItems = getRandomConfigItems(kotsConfig, valuesOpts)
+		for i, randomItem := range randomItems {
+			group, item, err := findConfigItem(randomItem.Name, kotsConfig)
+			if err != nil {
+				return err
+			}
+			randomItems[i].When, err = getConfigItemCondition(group, item, kotsConfig, name, valuesOpts)
+			if err != nil {
+				return err
+			}
+		}
+		derivedItems, err = getDerivedConfigItems(kotsConfig, name, valuesOpts)
+		if err != nil {
+			return errors.Wrap(err, "failed to get derived config items")
+		}
+		conditionalItems, err = getConditionalConfigItems(kotsConfig, name, valuesOpts)
+		if err != nil {
+			return errors.Wrap(err, "failed to get conditional config items")
+		}
+		whens, err = getConfigWhens(kotsConfig, name, valuesOpts)
+		if err != nil {
+			return errors.Wrap(err, "failed to get config whens")
+		}
+		passwordItems, err := getPasswordItems(kotsConfig, name, valuesOpts)
+		if err != nil {
+			return errors.Wrap(err, "failed to get password items")
+		}
+		hasPasswordItems = len(passwordItems) > 0
+	}
+
+	data := struct {
+		Name             string
+		RandomItems      []randomConfigItem
+		DerivedItems     []derivedConfigItem
+		ConditionalItems []conditionalConfigItem
+		Whens            []configWhen
+		WhenFalseValues  []string
+		Validations      bool
+		PasswordItems    bool
+	}{
+		Name:             name,
+		RandomItems:      randomItems,
+		DerivedItems:     derivedItems,
+		ConditionalItems: conditionalItems,
+		Whens:            whens,
+		WhenFalseValues:  whenFalseValues,
+		Validations:      len(valuesOpts.Validations) > 0,
+		PasswordItems:    hasPasswordItems,
+	}
+
+	var rendered bytes.Buffer
+	if err := helpersTemplate.Execute(&rendered, data); err != nil {
+		return errors.Wrap(err, "failed to render _helpers.tpl")
+	}
+
+	if err := os.WriteFile(fileName, rendered.Bytes(), 0644); err != nil {
+		return errors.Wrap(err, "failed to write _helpers.tpl")
+	}
+
+	return nil
+}
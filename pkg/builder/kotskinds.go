@@ -6,50 +6,127 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/pkg/errors"
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/client-go/kubernetes/scheme"
 )
 
-// getKOTSKind will find the requested kots kind in workspace
-func getKOTSKind(workspace string, g, v, k string) (*runtime.Object, error) {
-	decode := scheme.Codecs.UniversalDeserializer().Decode
+// workspaceIndex holds every yaml document in a workspace by gvk so that
+// the workspace is only walked and parsed once
+type workspaceIndex struct {
+	documents map[string][]*indexedDocument
+}
+
+type indexedDocument struct {
+	Path    string
+	Content []byte
+
+	decoded runtime.Object
+}
+
+// indexWorkspace will parse every yaml document in workspace into an index
+func indexWorkspace(workspace string) (*workspaceIndex, error) {
+	index := &workspaceIndex{
+		documents: map[string][]*indexedDocument{},
+	}
 
-	var foundObj *runtime.Object
 	err := filepath.Walk(workspace,
 		func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
 			if info.IsDir() {
 				return nil
 			}
 
-			yamlDoc, err := ioutil.ReadFile(path)
+			content, err := ioutil.ReadFile(path)
 			if err != nil {
 				return err
 			}
 
 			// be careful, some apps have binaries and non-yaml manifests mixed in
+			for _, doc := range yamlDocumentSeparatorRegex.Split(string(content), -1) {
+				if isEmptyYAMLDocument(doc) {
+					continue
+				}
 
-			gvk, err := getGVK(yamlDoc)
-			if err != nil {
-				return nil
-			}
-
-			if gvk == KOTSConfigGVK {
-				// decode it properly using a scheme
-				o, _, err := decode(yamlDoc, nil, nil)
+				gvk, err := getGVK([]byte(doc))
 				if err != nil {
-					fmt.Printf("failed to decode yaml: %s\n", err)
-					return err
+					continue
 				}
 
-				foundObj = &o
-				return nil
+				index.documents[gvk] = append(index.documents[gvk], &indexedDocument{
+					Path:    path,
+					Content: []byte(doc),
+				})
 			}
 
 			return nil
 		})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to index workspace")
+	}
+
+	return index, nil
+}
+
+// getKOTSKind will find the requested kots kind in the index, decoding it properly
+// using a scheme. nil is returned if there isn't one
+func (index *workspaceIndex) getKOTSKind(g, v, k string) (*runtime.Object, error) {
+	docs := index.documents[fmt.Sprintf("%s/%s/%s", g, v, k)]
+	if len(docs) == 0 {
+		return nil, nil
+	}
+
+	doc := docs[0]
+	if doc.decoded == nil {
+		decode := scheme.Codecs.UniversalDeserializer().Decode
+		o, _, err := decode(doc.Content, nil, nil)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode %s", doc.Path)
+		}
+		doc.decoded = o
+	}
+
+	return &doc.decoded, nil
+}
+
+// getKOTSConfig returns the kots config in the index, or nil if there isn't one
+func getKOTSConfig(index *workspaceIndex) (*kotsv1beta1.Config, error) {
+	objP, err := index.getKOTSKind("kots.io", "v1beta1", "Config")
 	if err != nil {
 		return nil, err
 	}
+	if objP == nil {
+		return nil, nil
+	}
+
+	obj := *objP
+	kotsConfig, ok := obj.(*kotsv1beta1.Config)
+	if !ok {
+		return nil, errors.Errorf("unexpected type %T for kots config", obj)
+	}
+
+	return kotsConfig, nil
+}
+
+// getKOTSApplication returns the kots application in the index, or nil if there isn't one
+func getKOTSApplication(index *workspaceIndex) (*kotsv1beta1.Application, error) {
+	objP, err := index.getKOTSKind("kots.io", "v1beta1", "Application")
+	if err != nil {
+		return nil, err
+	}
+	if objP == nil {
+		return nil, nil
+	}
+
+	obj := *objP
+	kotsApplication, ok := obj.(*kotsv1beta1.Application)
+	if !ok {
+		return nil, errors.Errorf("unexpected type %T for kots application", obj)
+	}
 
-	return foundObj, nil
+	return kotsApplication, nil
 }
@@ -0,0 +1,62 @@
+package builder
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_workspaceIndex_getKOTSKind(t *testing.T) {
+	req := require.New(t)
+
+	workspace := t.TempDir()
+
+	files := map[string]string{
+		"config.yaml": `apiVersion: kots.io/v1beta1
+kind: Config
+metadata:
+  name: config
+spec:
+  groups:
+    - name: group1
+      items:
+        - name: foo
+          type: text`,
+		"multi.yaml": `apiVersion: v1
+kind: Service
+metadata:
+  name: api
+---
+apiVersion: kots.io/v1beta1
+kind: Application
+metadata:
+  name: app
+spec:
+  title: App`,
+		"binary.dat": "\x00\x01not yaml: [",
+	}
+	for name, content := range files {
+		req.NoError(ioutil.WriteFile(filepath.Join(workspace, name), []byte(content), 0644))
+	}
+
+	index, err := indexWorkspace(workspace)
+	req.NoError(err)
+
+	kotsConfig, err := getKOTSConfig(index)
+	req.NoError(err)
+	req.NotNil(kotsConfig)
+	assert.Equal(t, "foo", kotsConfig.Spec.Groups[0].Items[0].Name)
+
+	app, err := index.getKOTSKind("kots.io", "v1beta1", "Application")
+	req.NoError(err)
+	assert.NotNil(t, app)
+
+	missing, err := index.getKOTSKind("kots.io", "v1beta1", "HelmChart")
+	req.NoError(err)
+	assert.Nil(t, missing)
+
+	assert.Len(t, index.documents["v1/Service"], 1)
+}
@@ -0,0 +1,110 @@
+package builder
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// kurlFunctionStatementRegex matches a kurl function at the start of a kots template
+	kurlFunctionStatementRegex = regexp.MustCompile("((?:{{repl|repl{{)\\s*)(KurlBool|KurlInt|KurlString|KurlOption)\\s+[\"`]([^\"`]+)[\"`]")
+	// kurlFunctionRegex matches a kurl function anywhere in a template, such as in an if
+	kurlFunctionRegex = regexp.MustCompile("(KurlBool|KurlInt|KurlString|KurlOption)\\s+[\"`]([^\"`]+)[\"`]")
+)
+
+// replaceKurlFunctions converts KurlBool, KurlInt, KurlString and KurlOption to lookups
+// in the kurl block of the values. the kots path "Longhorn.enabled" is found at
+// .Values.kurl.longhorn.enabled, which mirrors the spec of the kurl installer
+func replaceKurlFunctions(content []byte) ([]byte, error) {
+	updatedContent := kurlFunctionStatementRegex.ReplaceAllStringFunc(string(content), func(match string) string {
+		result := kurlFunctionStatementRegex.FindStringSubmatch(match)
+		return result[1] + kurlValuesLookup(result[2], result[3])
+	})
+
+	updatedContent = kurlFunctionRegex.ReplaceAllStringFunc(updatedContent, func(match string) string {
+		result := kurlFunctionRegex.FindStringSubmatch(match)
+		return fmt.Sprintf("(%s)", kurlValuesLookup(result[1], result[2]))
+	})
+
+	return []byte(updatedContent), nil
+}
+
+// kurlValuesLookup returns a template expression that looks up the kots kurl path in
+// .Values.kurl, falling back to the zero value that kots uses when it isn't set
+func kurlValuesLookup(function string, kurlPath string) string {
+	keys := []string{}
+	for _, segment := range strings.Split(kurlPath, ".") {
+		keys = append(keys, fmt.Sprintf("%q", lowerFirst(segment)))
+	}
+
+	switch function {
+	case "KurlBool":
+		return fmt.Sprintf("dig %s false .Values.kurl", strings.Join(keys, " "))
+	case "KurlInt":
+		return fmt.Sprintf("dig %s 0 .Values.kurl", strings.Join(keys, " "))
+	case "KurlOption":
+		return fmt.Sprintf("dig %s \"\" .Values.kurl | toString", strings.Join(keys, " "))
+	default:
+		return fmt.Sprintf("dig %s \"\" .Values.kurl", strings.Join(keys, " "))
+	}
+}
+
+func lowerFirst(s string) string {
+	if s == "" {
+		return s
+	}
+
+	return strings.ToLower(s[:1]) + s[1:]
+}
+
+// getKurlValues returns the spec of the kurl installer in the index, to be used
+// as the defaults for the kurl values. an empty map is returned if there isn't one
+func getKurlValues(index *workspaceIndex) (map[string]interface{}, error) {
+	docs := index.documents["cluster.kurl.sh/v1beta1/Installer"]
+	if len(docs) == 0 {
+		docs = index.documents["kurl.sh/v1beta1/Installer"]
+	}
+	if len(docs) == 0 {
+		return map[string]interface{}{}, nil
+	}
+
+	installer := struct {
+		Spec map[string]interface{} `yaml:"spec"`
+	}{}
+	if err := yaml.Unmarshal(docs[0].Content, &installer); err != nil {
+		return nil, errors.Wrapf(err, "failed to unmarshal kurl installer %s", docs[0].Path)
+	}
+
+	if installer.Spec == nil {
+		return map[string]interface{}{}, nil
+	}
+
+	return installer.Spec, nil
+}
+
+// createEmbeddedClusterValuesYAML will create a values file to use when installing
+// the chart to a kurl cluster
+func createEmbeddedClusterValuesYAML(workspace string) error {
+	values := map[string]interface{}{
+		"isKurl": true,
+	}
+
+	rendered, err := yaml.Marshal(values)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal values")
+	}
+
+	fileName := filepath.Join(workspace, "values-embedded-cluster.yaml")
+
+	if err := os.WriteFile(fileName, rendered, 0644); err != nil {
+		return errors.Wrap(err, "failed to write values-embedded-cluster.yaml")
+	}
+
+	return nil
+}
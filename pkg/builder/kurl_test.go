@@ -0,0 +1,50 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_replaceKurlFunctions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		expect  string
+	}{
+		{
+			name:    "no kurl functions",
+			content: `namespace: "test"`,
+			expect:  `namespace: "test"`,
+		},
+		{
+			name:    "KurlString",
+			content: `cidr: repl{{ KurlString "Kubernetes.ServiceCIDR" }}`,
+			expect:  `cidr: {{ dig "kubernetes" "serviceCIDR" "" .Values.kurl }}`,
+		},
+		{
+			name:    "KurlOption",
+			content: "storageClassName: '{{repl KurlOption `Rook.storageClass` }}'",
+			expect:  `storageClassName: '{{ dig "rook" "storageClass" "" .Values.kurl | toString }}'`,
+		},
+		{
+			name:    "KurlInt",
+			content: `port: repl{{ KurlInt "Kotsadm.uiBindPort" }}`,
+			expect:  `port: {{ dig "kotsadm" "uiBindPort" 0 .Values.kurl }}`,
+		},
+		{
+			name:    "KurlBool in an if",
+			content: `{{repl if KurlBool "Longhorn.enabled" }}`,
+			expect:  `{{ if (dig "longhorn" "enabled" false .Values.kurl) }}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			actual, err := replaceKurlFunctions([]byte(tt.content))
+			req.NoError(err)
+			assert.Equal(t, tt.expect, string(convertStatementDelimiters(actual)))
+		})
+	}
+}
@@ -0,0 +1,172 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// removedLabels are removed from the metadata of converted manifests, kots adds the app
+// slug label itself when it deploys
+var removedLabels = map[string]bool{
+	"kots.io/app-slug": true,
+}
+
+// commonLabels are the labels from _helpers.tpl. a manifest's own value for one of them is
+// kept, so that selectors on it still match
+var commonLabels = map[string]bool{
+	"helm.sh/chart":                true,
+	"app.kubernetes.io/name":       true,
+	"app.kubernetes.io/instance":   true,
+	"app.kubernetes.io/version":    true,
+	"app.kubernetes.io/managed-by": true,
+}
+
+var (
+	yamlIndentRegex   = regexp.MustCompile(`^( *)\S`)
+	yamlMapEntryRegex = regexp.MustCompile(`^( *)['"]?([^'":\s]+)['"]?:(?:\s|$)`)
+)
+
+// injectLabels adds the standard labels from _helpers.tpl that are missing from the top level
+// metadata of each document in content. only metadata.labels is changed, so selectors and
+// pod template labels, which are immutable on existing workloads, stay as they are
+func injectLabels(content []byte, chartName string) []byte {
+	docs := yamlDocumentSeparatorRegex.Split(string(content), -1)
+	separators := yamlDocumentSeparatorRegex.FindAllString(string(content), -1)
+
+	updated := ""
+	for i, doc := range docs {
+		if isEmptyYAMLDocument(doc) {
+			updated += doc
+		} else if isKOTS, _ := isKOTSManifest([]byte(doc)); isKOTS {
+			updated += doc
+		} else {
+			updated += injectLabelsInDocument(doc, chartName)
+		}
+
+		if i < len(separators) {
+			updated += separators[i]
+		}
+	}
+
+	return []byte(updated)
+}
+
+func injectLabelsInDocument(doc string, chartName string) string {
+	lines := strings.Split(doc, "\n")
+
+	metadataIdx := -1
+	for i, line := range lines {
+		if strings.TrimRight(line, " ") == "metadata:" {
+			metadataIdx = i
+			break
+		}
+	}
+	if metadataIdx == -1 {
+		return doc
+	}
+
+	// the children of metadata are the lines until the next top level key
+	metadataEnd := len(lines)
+	childIndent := ""
+	for i := metadataIdx + 1; i < len(lines); i++ {
+		indent, ok := yamlLineIndent(lines[i])
+		if !ok {
+			continue
+		}
+		if indent == "" {
+			metadataEnd = i
+			break
+		}
+		if childIndent == "" {
+			childIndent = indent
+		}
+	}
+	if childIndent == "" {
+		return doc
+	}
+
+	labelsIdx := -1
+	for i := metadataIdx + 1; i < metadataEnd; i++ {
+		m := yamlMapEntryRegex.FindStringSubmatch(lines[i])
+		if m != nil && m[1] == childIndent && m[2] == "labels" {
+			labelsIdx = i
+			break
+		}
+	}
+
+	labelIndent := childIndent + "  "
+	include := fmt.Sprintf(`{{- include "%s.labels" $ | nindent %d }}`, chartName, len(labelIndent))
+
+	if labelsIdx == -1 {
+		updated := append([]string{}, lines[:metadataIdx+1]...)
+		updated = append(updated, childIndent+"labels:", labelIndent+include)
+		updated = append(updated, lines[metadataIdx+1:]...)
+		return strings.Join(updated, "\n")
+	}
+
+	labelsValue := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(lines[labelsIdx]), "labels:"))
+	if labelsValue != "" && labelsValue != "{}" {
+		// flow style labels are left alone
+		return doc
+	}
+
+	labelsEnd := metadataEnd
+	firstLabel := true
+	for i := labelsIdx + 1; i < metadataEnd; i++ {
+		indent, ok := yamlLineIndent(lines[i])
+		if !ok {
+			continue
+		}
+		if len(indent) <= len(childIndent) {
+			labelsEnd = i
+			break
+		}
+		if firstLabel {
+			firstLabel = false
+			labelIndent = indent
+			include = fmt.Sprintf(`{{- include "%s.labels" $ | nindent %d }}`, chartName, len(labelIndent))
+		}
+	}
+
+	kept := []string{}
+	existing := []string{}
+	for i := labelsIdx + 1; i < labelsEnd; i++ {
+		m := yamlMapEntryRegex.FindStringSubmatch(lines[i])
+		if m != nil && m[1] == labelIndent {
+			if removedLabels[m[2]] {
+				continue
+			}
+			if commonLabels[m[2]] {
+				existing = append(existing, fmt.Sprintf("%q", m[2]))
+			}
+		}
+		kept = append(kept, lines[i])
+	}
+	if len(existing) > 0 {
+		include = fmt.Sprintf(`{{- include "%s.labelsWithout" (list $ %s) | nindent %d }}`, chartName, strings.Join(existing, " "), len(labelIndent))
+	}
+
+	updated := append([]string{}, lines[:labelsIdx]...)
+	updated = append(updated, childIndent+"labels:", labelIndent+include)
+	updated = append(updated, kept...)
+	updated = append(updated, lines[labelsEnd:]...)
+
+	return strings.Join(updated, "\n")
+}
+
+// yamlLineIndent returns the indent of a line of yaml. blank lines, comments and
+// template actions don't have one
+func yamlLineIndent(line string) (string, bool) {
+	m := yamlIndentRegex.FindStringSubmatch(line)
+	if m == nil {
+		return "", false
+	}
+
+	trimmed := strings.TrimSpace(line)
+	if strings.HasPrefix(trimmed, "#") || strings.HasPrefix(trimmed, "{{") {
+		return "", false
+	}
+
+	return m[1], true
+}
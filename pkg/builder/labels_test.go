@@ -0,0 +1,147 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_injectLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		expect  string
+	}{
+		{
+			name: "no labels",
+			content: `apiVersion: v1
+kind: Service
+metadata:
+  name: api
+spec:
+  selector:
+    app: api`,
+			expect: `apiVersion: v1
+kind: Service
+metadata:
+  labels:
+    {{- include "test.labels" $ | nindent 4 }}
+  name: api
+spec:
+  selector:
+    app: api`,
+		},
+		{
+			name: "existing labels, selectors untouched",
+			content: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+    name: api
+    labels:
+        app: api
+        kots.io/app-slug: my-app
+        app.kubernetes.io/name: api
+spec:
+    selector:
+        matchLabels:
+            app: api
+            kots.io/app-slug: my-app
+    template:
+        metadata:
+            labels:
+                app: api
+                kots.io/app-slug: my-app`,
+			expect: `apiVersion: apps/v1
+kind: Deployment
+metadata:
+    name: api
+    labels:
+        {{- include "test.labelsWithout" (list $ "app.kubernetes.io/name") | nindent 8 }}
+        app: api
+        app.kubernetes.io/name: api
+spec:
+    selector:
+        matchLabels:
+            app: api
+            kots.io/app-slug: my-app
+    template:
+        metadata:
+            labels:
+                app: api
+                kots.io/app-slug: my-app`,
+		},
+		{
+			name: "existing common labels are kept",
+			content: `apiVersion: v1
+kind: Service
+metadata:
+  name: api-server
+  labels:
+    app.kubernetes.io/name: api-server
+    app.kubernetes.io/instance: prod-api
+spec:
+  selector:
+    app.kubernetes.io/name: api-server`,
+			expect: `apiVersion: v1
+kind: Service
+metadata:
+  name: api-server
+  labels:
+    {{- include "test.labelsWithout" (list $ "app.kubernetes.io/name" "app.kubernetes.io/instance") | nindent 4 }}
+    app.kubernetes.io/name: api-server
+    app.kubernetes.io/instance: prod-api
+spec:
+  selector:
+    app.kubernetes.io/name: api-server`,
+		},
+		{
+			name: "empty labels",
+			content: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  labels: {}
+data:
+  a: b`,
+			expect: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  labels:
+    {{- include "test.labels" $ | nindent 4 }}
+data:
+  a: b`,
+		},
+		{
+			name: "multiple documents and kots manifests",
+			content: `apiVersion: kots.io/v1beta1
+kind: Config
+metadata:
+  name: config
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+`,
+			expect: `apiVersion: kots.io/v1beta1
+kind: Config
+metadata:
+  name: config
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  labels:
+    {{- include "test.labels" $ | nindent 4 }}
+  name: config
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := injectLabels([]byte(tt.content), "test")
+			assert.Equal(t, tt.expect, string(actual))
+		})
+	}
+}
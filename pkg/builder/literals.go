@@ -0,0 +1,58 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+)
+
+// escapeLiteralTemplates escapes every {{ }} in content that isn't a kots template, such as
+// prometheus alert templates or argo workflow parameters. kots only evaluates {{repl and
+// repl{{, so these are literal text that helm would otherwise try to evaluate
+func escapeLiteralTemplates(content []byte) []byte {
+	s := string(content)
+	if !strings.Contains(s, "{{") {
+		return content
+	}
+
+	var b strings.Builder
+	for {
+		start := strings.Index(s, "{{")
+		if start == -1 {
+			b.WriteString(s)
+			break
+		}
+		isKOTS := strings.HasPrefix(s[start:], "{{repl") || strings.HasSuffix(s[:start], "repl")
+		b.WriteString(s[:start])
+
+		end := strings.Index(s[start+2:], "}}")
+		if end == -1 {
+			// an unclosed {{ is still an error in helm
+			if isKOTS {
+				b.WriteString(s[start:])
+				break
+			}
+			b.WriteString(escapeLiteralTemplate("{{"))
+			s = s[start+2:]
+			continue
+		}
+		end += start + 4
+
+		if isKOTS {
+			b.WriteString(s[start:end])
+		} else {
+			b.WriteString(escapeLiteralTemplate(s[start:end]))
+		}
+		s = s[end:]
+	}
+
+	return []byte(b.String())
+}
+
+// escapeLiteralTemplate returns a helm template that renders literal as it is
+func escapeLiteralTemplate(literal string) string {
+	if strings.Contains(literal, "`") {
+		return fmt.Sprintf("{{ %q }}", literal)
+	}
+
+	return "{{`" + literal + "`}}"
+}
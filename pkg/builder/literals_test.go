@@ -0,0 +1,71 @@
+package builder
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_escapeLiteralTemplates(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		expect  string
+	}{
+		{
+			name:    "no templates",
+			content: `a: b`,
+			expect:  `a: b`,
+		},
+		{
+			name:    "prometheus alert",
+			content: `summary: "Instance {{ $labels.instance }} has been down for {{ $value }}"`,
+			expect:  "summary: \"Instance {{`{{ $labels.instance }}`}} has been down for {{`{{ $value }}`}}\"",
+		},
+		{
+			name:    "kots templates are kept",
+			content: `a: '{{repl ConfigOption "a" }} {{ .Status }} repl{{ ConfigOption "b" }}'`,
+			expect:  "a: '{{repl ConfigOption \"a\" }} {{`{{ .Status }}`}} repl{{ ConfigOption \"b\" }}'",
+		},
+		{
+			name: "trimming and multiple lines",
+			content: `text: |
+  {{- range .Alerts }}
+  {{ .Annotations.summary }}
+  {{- end }}`,
+			expect: "text: |\n  {{`{{- range .Alerts }}`}}\n  {{`{{ .Annotations.summary }}`}}\n  {{`{{- end }}`}}",
+		},
+		{
+			name:    "backticks",
+			content: "cmd: {{ printf `%s` .x }}",
+			expect:  "cmd: {{ \"{{ printf `%s` .x }}\" }}",
+		},
+		{
+			name:    "unclosed",
+			content: `a: {{ b`,
+			expect:  "a: {{`{{`}} b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+
+			actual := escapeLiteralTemplates([]byte(tt.content))
+			assert.Equal(t, tt.expect, string(actual))
+
+			if kotsTemplateRegex.MatchString(tt.content) {
+				return
+			}
+
+			// helm renders the escaped content as the original
+			tmpl, err := template.New("test").Parse(string(actual))
+			req.NoError(err)
+			rendered := bytes.Buffer{}
+			req.NoError(tmpl.Execute(&rendered, nil))
+			assert.Equal(t, tt.content, rendered.String())
+		})
+	}
+}
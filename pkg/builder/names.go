@@ -0,0 +1,294 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// unprefixedKinds are never renamed. namespaces are referenced by name from outside the
+// chart and crd names must be <plural>.<group>
+var unprefixedKinds = map[string]bool{
+	"Namespace":                true,
+	"CustomResourceDefinition": true,
+}
+
+// nameReference is a field that holds the name of another resource
+type nameReference struct {
+	// PathSuffix is matched against the end of the yaml path of the field
+	PathSuffix string
+	Kinds      []string
+	// Host is set when the field is only renamed when it's a url or host name that contains
+	// the name, such as an env var value
+	Host bool
+	// SiblingKind is set when the kind key next to the field must be one of Kinds
+	SiblingKind bool
+}
+
+var nameReferences = []nameReference{
+	{PathSuffix: "env[].value", Kinds: []string{"Service"}, Host: true},
+	{PathSuffix: "env[].valueFrom.configMapKeyRef.name", Kinds: []string{"ConfigMap"}},
+	{PathSuffix: "env[].valueFrom.secretKeyRef.name", Kinds: []string{"Secret"}},
+	{PathSuffix: "envFrom[].configMapRef.name", Kinds: []string{"ConfigMap"}},
+	{PathSuffix: "envFrom[].secretRef.name", Kinds: []string{"Secret"}},
+	{PathSuffix: "volumes[].configMap.name", Kinds: []string{"ConfigMap"}},
+	{PathSuffix: "volumes[].secret.secretName", Kinds: []string{"Secret"}},
+	{PathSuffix: "volumes[].persistentVolumeClaim.claimName", Kinds: []string{"PersistentVolumeClaim"}},
+	{PathSuffix: "sources[].configMap.name", Kinds: []string{"ConfigMap"}},
+	{PathSuffix: "sources[].secret.name", Kinds: []string{"Secret"}},
+	{PathSuffix: "imagePullSecrets[].name", Kinds: []string{"Secret"}},
+	{PathSuffix: "serviceAccountName", Kinds: []string{"ServiceAccount"}},
+	{PathSuffix: "priorityClassName", Kinds: []string{"PriorityClass"}},
+	{PathSuffix: "storageClassName", Kinds: []string{"StorageClass"}},
+	{PathSuffix: "ingressClassName", Kinds: []string{"IngressClass"}},
+	{PathSuffix: "roleRef.name", Kinds: []string{"Role", "ClusterRole"}, SiblingKind: true},
+	{PathSuffix: "subjects[].name", Kinds: []string{"ServiceAccount"}, SiblingKind: true},
+	{PathSuffix: "backend.service.name", Kinds: []string{"Service"}},
+	{PathSuffix: "backend.serviceName", Kinds: []string{"Service"}},
+	{PathSuffix: "tls[].secretName", Kinds: []string{"Secret"}},
+	{PathSuffix: "clientConfig.service.name", Kinds: []string{"Service"}},
+	{PathSuffix: "scaleTargetRef.name", Kinds: []string{"Deployment", "StatefulSet", "ReplicaSet"}},
+	{PathSuffix: "spec.serviceName", Kinds: []string{"Service"}},
+	{PathSuffix: "spec.service.name", Kinds: []string{"Service"}},
+}
+
+var (
+	yamlScalarLineRegex = regexp.MustCompile(`^(\s*(?:- )?(?:"[^"]*"|'[^']*'|[^\s:#{}\[\],]+)\s*:\s+)(["']?)([^"'\s#{}]+)(["']?)(\s*(?:#.*)?)$`)
+	yamlKindRegex       = regexp.MustCompile(`(?m)^kind:\s*["']?(\w+)`)
+	// yamlKindKeyRegex matches the kind key of a mapping, without its indent
+	yamlKindKeyRegex = regexp.MustCompile(`^kind:\s*["']?([^"'\s#]+)`)
+)
+
+// prefixedHost matches a url or host name that points at a prefixed Service
+type prefixedHost struct {
+	Name  string
+	Regex *regexp.Regexp
+}
+
+// getPrefixedNames returns the names of the resources in the workspace, by kind, that are
+// prefixed with the release fullname. names that are templated are not included
+func getPrefixedNames(index *workspaceIndex) map[string][]string {
+	type namedResource struct {
+		Kind     string `yaml:"kind"`
+		Metadata struct {
+			Name string `yaml:"name"`
+		} `yaml:"metadata"`
+	}
+
+	seen := map[string]map[string]bool{}
+	for _, docs := range index.documents {
+		for _, doc := range docs {
+			if isKOTS, _ := isKOTSManifest(doc.Content); isKOTS {
+				continue
+			}
+
+			r := namedResource{}
+			if err := yaml.Unmarshal(doc.Content, &r); err != nil {
+				continue
+			}
+			if r.Kind == "" || r.Metadata.Name == "" || unprefixedKinds[r.Kind] {
+				continue
+			}
+			if strings.Contains(r.Metadata.Name, "{{") || strings.Contains(r.Metadata.Name, "repl") {
+				continue
+			}
+
+			if seen[r.Kind] == nil {
+				seen[r.Kind] = map[string]bool{}
+			}
+			seen[r.Kind][r.Metadata.Name] = true
+		}
+	}
+
+	names := map[string][]string{}
+	for kind, kindNames := range seen {
+		for name := range kindNames {
+			names[kind] = append(names[kind], name)
+		}
+		sort.Strings(names[kind])
+	}
+
+	return names
+}
+
+// getPrefixedHosts compiles the host pattern for each prefixed Service. it's done once when the
+// names are collected, not for every line that could hold a host
+func getPrefixedHosts(names map[string][]string) []prefixedHost {
+	hosts := []prefixedHost{}
+	for _, name := range names["Service"] {
+		hosts = append(hosts, prefixedHost{
+			Name:  name,
+			Regex: regexp.MustCompile(fmt.Sprintf(`^([a-z][a-z0-9+.-]*://)?%s((?:\.[a-z0-9-]+(?:\.svc(?:\.[a-z0-9-]+)*)?)?(?::[0-9]+)?)(/.*)?$`, regexp.QuoteMeta(name))),
+		})
+	}
+
+	return hosts
+}
+
+// prefixNames prefixes the name of each resource in content with the release fullname, and
+// rewrites the fields that reference a prefixed resource so they still match. hosts are the
+// Service host patterns from getPrefixedHosts
+func prefixNames(content []byte, chartName string, names map[string][]string, hosts []prefixedHost) []byte {
+	docs := yamlDocumentSeparatorRegex.Split(string(content), -1)
+	separators := yamlDocumentSeparatorRegex.FindAllString(string(content), -1)
+
+	updated := ""
+	for i, doc := range docs {
+		if isKOTS, _ := isKOTSManifest([]byte(doc)); isKOTS {
+			updated += doc
+		} else {
+			updated += prefixNamesInDocument(doc, chartName, names, hosts)
+		}
+
+		if i < len(separators) {
+			updated += separators[i]
+		}
+	}
+
+	return []byte(updated)
+}
+
+func prefixNamesInDocument(doc string, chartName string, names map[string][]string, hosts []prefixedHost) string {
+	kind := ""
+	if m := yamlKindRegex.FindStringSubmatch(doc); m != nil {
+		kind = m[1]
+	}
+
+	lines := strings.Split(doc, "\n")
+	for i, line := range lines {
+		m := yamlScalarLineRegex.FindStringSubmatch(line)
+		if m == nil || m[2] != m[4] {
+			continue
+		}
+		value := m[3]
+
+		path := yamlPathForLine(lines, i)
+		if path == "metadata.name" {
+			if isPrefixedName(names, []string{kind}, value) {
+				lines[i] = m[1] + m[2] + prefixedNameInclude(chartName, value) + m[4] + m[5]
+			}
+			continue
+		}
+
+		for _, ref := range nameReferences {
+			if path != ref.PathSuffix && !strings.HasSuffix(path, "."+ref.PathSuffix) {
+				continue
+			}
+
+			if ref.Host {
+				if updatedValue, ok := prefixHost(value, chartName, hosts); ok {
+					lines[i] = m[1] + m[2] + updatedValue + m[4] + m[5]
+				}
+			} else if ref.SiblingKind && !containsString(ref.Kinds, yamlSiblingValue(lines, i, yamlKindKeyRegex)) {
+				// a subject or role of another kind can have the same name
+			} else if isPrefixedName(names, ref.Kinds, value) {
+				lines[i] = m[1] + m[2] + prefixedNameInclude(chartName, value) + m[4] + m[5]
+			}
+			break
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// prefixHost rewrites a url or host name that points at a prefixed Service, such as
+// http://api:3000/path, api:3000 or api.default.svc.cluster.local. a bare name is left
+// alone since it's as likely to be a word that happens to match, like MODE=api
+func prefixHost(value string, chartName string, hosts []prefixedHost) (string, bool) {
+	for _, host := range hosts {
+		m := host.Regex.FindStringSubmatch(value)
+		if m == nil || (m[1] == "" && m[2] == "") {
+			continue
+		}
+		return m[1] + prefixedNameInclude(chartName, host.Name) + m[2] + m[3], true
+	}
+
+	return "", false
+}
+
+// yamlSiblingValue returns the value of the key matched by keyRegex in the same mapping as
+// the key on line idx. keyRegex is matched without the indent and captures the value
+func yamlSiblingValue(lines []string, idx int, keyRegex *regexp.Regexp) string {
+	indent := yamlKeyIndent(lines[idx])
+
+	check := func(j int) (string, bool) {
+		trimmed := strings.TrimLeft(lines[j], " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			return "", true
+		}
+		lineIndent := yamlKeyIndent(lines[j])
+		if lineIndent < indent {
+			return "", false
+		}
+		if lineIndent == indent {
+			if m := keyRegex.FindStringSubmatch(strings.TrimPrefix(trimmed, "- ")); m != nil {
+				return m[1], false
+			}
+		}
+		return "", true
+	}
+
+	for j := idx; j >= 0; j-- {
+		value, more := check(j)
+		if value != "" {
+			return value
+		}
+		if !more || strings.HasPrefix(strings.TrimLeft(lines[j], " "), "- ") {
+			break
+		}
+	}
+	for j := idx + 1; j < len(lines); j++ {
+		if strings.HasPrefix(strings.TrimLeft(lines[j], " "), "- ") && yamlKeyIndent(lines[j]) == indent {
+			// the next item of the list
+			break
+		}
+		value, more := check(j)
+		if value != "" {
+			return value
+		}
+		if !more {
+			break
+		}
+	}
+
+	return ""
+}
+
+// yamlKeyIndent is the column of the key on line, after the dash of a list item
+func yamlKeyIndent(line string) int {
+	trimmed := strings.TrimLeft(line, " ")
+	indent := len(line) - len(trimmed)
+	if strings.HasPrefix(trimmed, "- ") {
+		indent += 2
+	}
+	return indent
+}
+
+func isPrefixedName(names map[string][]string, kinds []string, name string) bool {
+	for _, kind := range kinds {
+		for _, n := range names[kind] {
+			if n == name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func prefixedNameInclude(chartName string, name string) string {
+	return fmt.Sprintf(`{{ include "%s.prefixedName" (list $ %q) }}`, chartName, name)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
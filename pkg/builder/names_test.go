@@ -0,0 +1,135 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_prefixNames(t *testing.T) {
+	names := map[string][]string{
+		"ConfigMap":      {"api-config"},
+		"Deployment":     {"web"},
+		"Service":        {"api"},
+		"ServiceAccount": {"api"},
+		"ClusterRole":    {"api-reader"},
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		expect  string
+	}{
+		{
+			name: "resource name",
+			content: `apiVersion: v1
+kind: Service
+metadata:
+  name: api
+spec:
+  selector:
+    app: api`,
+			expect: `apiVersion: v1
+kind: Service
+metadata:
+  name: {{ include "test.prefixedName" (list $ "api") }}
+spec:
+  selector:
+    app: api`,
+		},
+		{
+			name: "references in a pod spec",
+			content: `kind: Deployment
+metadata:
+  name: web
+spec:
+  template:
+    spec:
+      serviceAccountName: api
+      containers:
+        - name: web
+          env:
+            - name: API_URL
+              value: "http://api:3000/v1"
+            - name: MODE
+              value: api-server
+            - name: ROLE
+              value: api
+            - name: API_HOST
+              value: api.default.svc.cluster.local
+            - name: API_ADDR
+              value: api:3000
+            - name: API_PATH
+              value: api/v1
+          envFrom:
+            - configMapRef:
+                name: api-config
+            - secretRef:
+                name: external`,
+			expect: `kind: Deployment
+metadata:
+  name: {{ include "test.prefixedName" (list $ "web") }}
+spec:
+  template:
+    spec:
+      serviceAccountName: {{ include "test.prefixedName" (list $ "api") }}
+      containers:
+        - name: web
+          env:
+            - name: API_URL
+              value: "http://{{ include "test.prefixedName" (list $ "api") }}:3000/v1"
+            - name: MODE
+              value: api-server
+            - name: ROLE
+              value: api
+            - name: API_HOST
+              value: {{ include "test.prefixedName" (list $ "api") }}.default.svc.cluster.local
+            - name: API_ADDR
+              value: {{ include "test.prefixedName" (list $ "api") }}:3000
+            - name: API_PATH
+              value: api/v1
+          envFrom:
+            - configMapRef:
+                name: {{ include "test.prefixedName" (list $ "api-config") }}
+            - secretRef:
+                name: external`,
+		},
+		{
+			name: "role binding",
+			content: `kind: ClusterRoleBinding
+metadata:
+  name: api-reader
+roleRef:
+  kind: ClusterRole
+  name: api-reader
+subjects:
+  - kind: ServiceAccount
+    name: api
+  - kind: User
+    name: admin
+  - name: api
+    kind: User
+    apiGroup: rbac.authorization.k8s.io`,
+			expect: `kind: ClusterRoleBinding
+metadata:
+  name: api-reader
+roleRef:
+  kind: ClusterRole
+  name: {{ include "test.prefixedName" (list $ "api-reader") }}
+subjects:
+  - kind: ServiceAccount
+    name: {{ include "test.prefixedName" (list $ "api") }}
+  - kind: User
+    name: admin
+  - name: api
+    kind: User
+    apiGroup: rbac.authorization.k8s.io`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := prefixNames([]byte(tt.content), "test", names, getPrefixedHosts(names))
+			assert.Equal(t, tt.expect, string(actual))
+		})
+	}
+}
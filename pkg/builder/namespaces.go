@@ -0,0 +1,360 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+const namespacesTemplate = "kots2helm-namespaces.yaml"
+
+// pullSecretNamespaceVariable is the template variable that holds the namespace a copy of
+// an image pull secret is rendered to
+const pullSecretNamespaceVariable = "$kots2helmNamespace"
+
+// namespaceOpts decide which namespace references are rewritten to the release namespace
+type namespaceOpts struct {
+	// AppNamespaces are the namespaces the app is installed to. nothing is rewritten when
+	// it's empty
+	AppNamespaces []string `json:"appNamespaces,omitempty"`
+	// OtherNamespaces are created by the app, and are never the app's namespace
+	OtherNamespaces []string `json:"otherNamespaces,omitempty"`
+	// AdditionalNamespaces are the additionalNamespaces of the kots application. image pull
+	// secrets are copied into them
+	AdditionalNamespaces []string `json:"additionalNamespaces,omitempty"`
+}
+
+// namespaceReferences are the fields, other than metadata.namespace, that name a namespace
+var namespaceReferences = []string{
+	"subjects[].namespace",
+	"clientConfig.service.namespace",
+	"spec.service.namespace",
+}
+
+// releaseNamespace is the template for the release namespace in rewritten references. it's
+// read from the root context so that it works inside range and with
+const releaseNamespace = "{{ $.Release.Namespace }}"
+
+// clusterScopedKinds are the kinds that don't have a namespace, so they aren't counted when
+// the app's namespace is inferred
+var clusterScopedKinds = map[string]bool{
+	"Namespace":                      true,
+	"ClusterRole":                    true,
+	"ClusterRoleBinding":             true,
+	"CustomResourceDefinition":       true,
+	"StorageClass":                   true,
+	"PersistentVolume":               true,
+	"PriorityClass":                  true,
+	"MutatingWebhookConfiguration":   true,
+	"ValidatingWebhookConfiguration": true,
+	"APIService":                     true,
+}
+
+var (
+	yamlSecretTypeRegex       = regexp.MustCompile(`(?m)^type:\s*["']?kubernetes.io/dockerconfigjson["']?\s*$`)
+	serviceHostNamespaceRegex = regexp.MustCompile(`\.([a-z0-9-]+)(\.svc(?:[.:/"'\s]|$))`)
+)
+
+// getNamespaceOpts finds the namespaces that are created by the app in the index. when the
+// app's namespaces aren't given, they're inferred from the manifests
+func getNamespaceOpts(index *workspaceIndex, appNamespaces []string) (namespaceOpts, error) {
+	additionalNamespaces, err := getAdditionalNamespaces(index)
+	if err != nil {
+		return namespaceOpts{}, err
+	}
+
+	type namedResource struct {
+		Metadata struct {
+			Name string `yaml:"name"`
+		} `yaml:"metadata"`
+	}
+
+	otherNamespaces := append([]string{}, additionalNamespaces...)
+	for _, doc := range index.documents["v1/Namespace"] {
+		r := namedResource{}
+		if err := yaml.Unmarshal(doc.Content, &r); err != nil {
+			continue
+		}
+		if r.Metadata.Name != "" {
+			otherNamespaces = append(otherNamespaces, r.Metadata.Name)
+		}
+	}
+	sort.Strings(otherNamespaces)
+
+	if len(appNamespaces) == 0 {
+		if namespace := inferAppNamespace(index, otherNamespaces); namespace != "" {
+			appNamespaces = []string{namespace}
+		}
+	}
+
+	return namespaceOpts{
+		AppNamespaces:        appNamespaces,
+		OtherNamespaces:      otherNamespaces,
+		AdditionalNamespaces: additionalNamespaces,
+	}, nil
+}
+
+// getAdditionalNamespaces returns the additionalNamespaces of the kots application. the
+// "*" wildcard and templated namespaces can't be created by the chart and are skipped
+func getAdditionalNamespaces(index *workspaceIndex) ([]string, error) {
+	kotsApplication, err := getKOTSApplication(index)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get application")
+	}
+	if kotsApplication == nil {
+		return nil, nil
+	}
+
+	additionalNamespaces := []string{}
+	for _, namespace := range kotsApplication.Spec.AdditionalNamespaces {
+		if namespace == "*" || strings.Contains(namespace, "{{") || strings.Contains(namespace, "repl") {
+			continue
+		}
+		additionalNamespaces = append(additionalNamespaces, namespace)
+	}
+
+	return additionalNamespaces, nil
+}
+
+// inferAppNamespace returns the hard-coded namespace that more than half of the namespaced
+// manifests are in, which is the namespace the app was installed to. manifests without a
+// namespace, or with the Namespace template function, are installed to the app's namespace
+// too, so they count against every hard-coded one. it's empty when no namespace is shared
+// by most manifests, so that the namespaces of other components are left alone
+func inferAppNamespace(index *workspaceIndex, otherNamespaces []string) string {
+	type namespacedResource struct {
+		Metadata struct {
+			Namespace string `yaml:"namespace"`
+		} `yaml:"metadata"`
+	}
+
+	keys := []string{}
+	for key := range index.documents {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	total := 0
+	counts := map[string]int{}
+	for _, key := range keys {
+		if clusterScopedKinds[key[strings.LastIndex(key, "/")+1:]] {
+			continue
+		}
+		for _, doc := range index.documents[key] {
+			if isKOTS, _ := isKOTSManifest(doc.Content); isKOTS {
+				continue
+			}
+			total++
+
+			r := namespacedResource{}
+			if err := yaml.Unmarshal(doc.Content, &r); err != nil {
+				continue
+			}
+			if r.Metadata.Namespace != "" && !kotsTemplateRegex.MatchString(r.Metadata.Namespace) {
+				counts[r.Metadata.Namespace]++
+			}
+		}
+	}
+
+	for namespace, count := range counts {
+		if count*2 <= total || strings.HasPrefix(namespace, "kube-") {
+			continue
+		}
+		for _, otherNamespace := range otherNamespaces {
+			if namespace == otherNamespace {
+				return ""
+			}
+		}
+		return namespace
+	}
+
+	return ""
+}
+
+func (opts namespaceOpts) isAppNamespace(namespace string) bool {
+	for _, appNamespace := range opts.AppNamespaces {
+		if namespace == appNamespace {
+			return true
+		}
+	}
+
+	return false
+}
+
+// rewriteNamespaces changes the references to the app's namespace in content to the release
+// namespace. image pull secrets are copied to each additional namespace
+func rewriteNamespaces(content []byte, opts namespaceOpts) []byte {
+	docs := yamlDocumentSeparatorRegex.Split(string(content), -1)
+	separators := yamlDocumentSeparatorRegex.FindAllString(string(content), -1)
+
+	updated := ""
+	for i, doc := range docs {
+		if isEmptyYAMLDocument(doc) {
+			updated += doc
+		} else if isKOTS, _ := isKOTSManifest([]byte(doc)); isKOTS {
+			updated += doc
+		} else if len(opts.AdditionalNamespaces) > 0 && isPullSecret(doc) && isInAppNamespace(doc, opts) {
+			updated += copyPullSecret(doc, opts)
+		} else {
+			updated += rewriteNamespacesInDocument(doc, opts, releaseNamespace)
+		}
+
+		if i < len(separators) {
+			updated += separators[i]
+		}
+	}
+
+	return []byte(updated)
+}
+
+func rewriteNamespacesInDocument(doc string, opts namespaceOpts, releaseNamespace string) string {
+	lines := strings.Split(doc, "\n")
+	for i, line := range lines {
+		if strings.Contains(line, ".svc") && strings.HasSuffix(yamlPathForLine(lines, i), "env[].value") {
+			// service host names like api.my-namespace.svc.cluster.local
+			lines[i] = serviceHostNamespaceRegex.ReplaceAllStringFunc(line, func(match string) string {
+				m := serviceHostNamespaceRegex.FindStringSubmatch(match)
+				if !opts.isAppNamespace(m[1]) {
+					return match
+				}
+				return "." + releaseNamespace + m[2]
+			})
+			continue
+		}
+
+		m := yamlScalarLineRegex.FindStringSubmatch(line)
+		if m == nil || m[2] != m[4] {
+			continue
+		}
+
+		path := yamlPathForLine(lines, i)
+		if path != "metadata.namespace" && !isNamespaceReference(path) {
+			continue
+		}
+		if opts.isAppNamespace(m[3]) {
+			lines[i] = m[1] + m[2] + releaseNamespace + m[4] + m[5]
+		}
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func isNamespaceReference(path string) bool {
+	for _, ref := range namespaceReferences {
+		if path == ref || strings.HasSuffix(path, "."+ref) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isInAppNamespace is true when doc doesn't have a namespace, or it's the app's namespace
+func isInAppNamespace(doc string, opts namespaceOpts) bool {
+	lines := strings.Split(doc, "\n")
+	for i, line := range lines {
+		m := yamlScalarLineRegex.FindStringSubmatch(line)
+		if m != nil && yamlPathForLine(lines, i) == "metadata.namespace" {
+			return m[2] == m[4] && opts.isAppNamespace(m[3])
+		}
+	}
+
+	return true
+}
+
+func isPullSecret(doc string) bool {
+	m := yamlKindRegex.FindStringSubmatch(doc)
+	return m != nil && m[1] == "Secret" && yamlSecretTypeRegex.MatchString(doc)
+}
+
+// copyPullSecret renders an image pull secret once for the release namespace and once for
+// each additional namespace, the same as kots copies its pull secret to them
+func copyPullSecret(doc string, opts namespaceOpts) string {
+	namespace := fmt.Sprintf("{{ %s }}", pullSecretNamespaceVariable)
+	rewritten := rewriteNamespacesInDocument(doc, opts, namespace)
+	if !strings.Contains(rewritten, namespace) {
+		rewritten = setMetadataNamespace(rewritten, namespace)
+	}
+
+	return fmt.Sprintf(`{{- $kots2helmNamespaces := list .Release.Namespace }}
+{{- if .Values.additionalNamespaces.create }}
+{{- $kots2helmNamespaces = concat $kots2helmNamespaces .Values.additionalNamespaces.names }}
+{{- end }}
+{{- range %s := $kots2helmNamespaces }}
+{{- with $ }}
+---
+%s
+{{- end }}
+{{- end }}
+`, pullSecretNamespaceVariable, strings.Trim(rewritten, "\n"))
+}
+
+// setMetadataNamespace adds a namespace to the top level metadata of doc
+func setMetadataNamespace(doc string, namespace string) string {
+	lines := strings.Split(doc, "\n")
+	for i, line := range lines {
+		if strings.TrimRight(line, " ") != "metadata:" {
+			continue
+		}
+
+		childIndent := "  "
+		for _, child := range lines[i+1:] {
+			if indent, ok := yamlLineIndent(child); ok {
+				if indent != "" {
+					childIndent = indent
+				}
+				break
+			}
+		}
+
+		updated := append([]string{}, lines[:i+1]...)
+		updated = append(updated, childIndent+"namespace: "+namespace)
+		updated = append(updated, lines[i+1:]...)
+		return strings.Join(updated, "\n")
+	}
+
+	return doc
+}
+
+// createNamespacesTemplate will create a template with a Namespace for each of the kots
+// application's additionalNamespaces. they're created unless additionalNamespaces.create is false
+func createNamespacesTemplate(workspace string, name string, index *workspaceIndex) error {
+	additionalNamespaces, err := getAdditionalNamespaces(index)
+	if err != nil {
+		return err
+	}
+	if len(additionalNamespaces) == 0 {
+		return nil
+	}
+
+	fileName := filepath.Join(workspace, "templates", namespacesTemplate)
+	if _, err := os.Stat(fileName); err == nil {
+		return errors.Errorf("templates/%s already exists in the input dir", namespacesTemplate)
+	}
+
+	namespaces := fmt.Sprintf(`{{- if .Values.additionalNamespaces.create }}
+{{- range .Values.additionalNamespaces.names }}
+---
+apiVersion: v1
+kind: Namespace
+metadata:
+  name: {{ . }}
+  labels:
+    {{- include "%s.labels" $ | nindent 4 }}
+{{- end }}
+{{- end }}
+`, name)
+
+	if err := ioutil.WriteFile(fileName, []byte(namespaces), 0644); err != nil {
+		return errors.Wrap(err, "failed to write namespaces")
+	}
+
+	return nil
+}
@@ -0,0 +1,182 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_rewriteNamespaces(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    namespaceOpts
+		content string
+		expect  string
+	}{
+		{
+			name: "app namespace and other namespaces",
+			opts: namespaceOpts{
+				AppNamespaces:   []string{"my-app"},
+				OtherNamespaces: []string{"monitoring"},
+			},
+			content: `kind: RoleBinding
+metadata:
+  name: reader
+  namespace: my-app
+subjects:
+  - kind: ServiceAccount
+    name: api
+    namespace: "my-app"
+  - kind: ServiceAccount
+    name: prometheus
+    namespace: monitoring
+  - kind: ServiceAccount
+    name: dns
+    namespace: kube-system`,
+			expect: `kind: RoleBinding
+metadata:
+  name: reader
+  namespace: {{ $.Release.Namespace }}
+subjects:
+  - kind: ServiceAccount
+    name: api
+    namespace: "{{ $.Release.Namespace }}"
+  - kind: ServiceAccount
+    name: prometheus
+    namespace: monitoring
+  - kind: ServiceAccount
+    name: dns
+    namespace: kube-system`,
+		},
+		{
+			name: "app namespaces are set",
+			opts: namespaceOpts{
+				AppNamespaces: []string{"my-app"},
+			},
+			content: `kind: Pod
+metadata:
+  namespace: default
+spec:
+  containers:
+    - name: api
+      env:
+        - name: DB_HOST
+          value: postgres.my-app.svc.cluster.local
+        - name: SEARCH
+          value: http://search.default.svc:9200`,
+			expect: `kind: Pod
+metadata:
+  namespace: default
+spec:
+  containers:
+    - name: api
+      env:
+        - name: DB_HOST
+          value: postgres.{{ $.Release.Namespace }}.svc.cluster.local
+        - name: SEARCH
+          value: http://search.default.svc:9200`,
+		},
+		{
+			name: "image pull secret is copied to additional namespaces",
+			opts: namespaceOpts{
+				OtherNamespaces:      []string{"monitoring"},
+				AdditionalNamespaces: []string{"monitoring"},
+			},
+			content: `apiVersion: v1
+kind: Secret
+metadata:
+  name: registry
+type: kubernetes.io/dockerconfigjson
+data:
+  .dockerconfigjson: e30=
+`,
+			expect: `{{- $kots2helmNamespaces := list .Release.Namespace }}
+{{- if .Values.additionalNamespaces.create }}
+{{- $kots2helmNamespaces = concat $kots2helmNamespaces .Values.additionalNamespaces.names }}
+{{- end }}
+{{- range $kots2helmNamespace := $kots2helmNamespaces }}
+{{- with $ }}
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  namespace: {{ $kots2helmNamespace }}
+  name: registry
+type: kubernetes.io/dockerconfigjson
+data:
+  .dockerconfigjson: e30=
+{{- end }}
+{{- end }}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := rewriteNamespaces([]byte(tt.content), tt.opts)
+			assert.Equal(t, tt.expect, string(actual))
+		})
+	}
+}
+
+func Test_getNamespaceOpts(t *testing.T) {
+	doc := func(content string) *indexedDocument {
+		return &indexedDocument{Content: []byte(content)}
+	}
+
+	tests := []struct {
+		name      string
+		documents map[string][]*indexedDocument
+		expect    []string
+	}{
+		{
+			name: "most manifests share a namespace",
+			documents: map[string][]*indexedDocument{
+				"apps/v1/Deployment": {
+					doc("kind: Deployment\nmetadata:\n  name: api\n  namespace: my-app\n"),
+					doc("kind: Deployment\nmetadata:\n  name: web\n  namespace: my-app\n"),
+				},
+				"rbac.authorization.k8s.io/v1/RoleBinding": {
+					doc("kind: RoleBinding\nmetadata:\n  name: prometheus\n  namespace: monitoring\n"),
+				},
+				"rbac.authorization.k8s.io/v1/ClusterRole": {
+					doc("kind: ClusterRole\nmetadata:\n  name: reader\n"),
+				},
+			},
+			expect: []string{"my-app"},
+		},
+		{
+			name: "manifests without a namespace",
+			documents: map[string][]*indexedDocument{
+				"apps/v1/Deployment": {
+					doc("kind: Deployment\nmetadata:\n  name: api\n"),
+					doc("kind: Deployment\nmetadata:\n  name: web\n  namespace: repl{{ Namespace }}\n"),
+				},
+				"rbac.authorization.k8s.io/v1/RoleBinding": {
+					doc("kind: RoleBinding\nmetadata:\n  name: prometheus\n  namespace: monitoring\n"),
+				},
+			},
+			expect: nil,
+		},
+		{
+			name: "namespace created by the app",
+			documents: map[string][]*indexedDocument{
+				"v1/Namespace": {
+					doc("kind: Namespace\nmetadata:\n  name: monitoring\n"),
+				},
+				"rbac.authorization.k8s.io/v1/RoleBinding": {
+					doc("kind: RoleBinding\nmetadata:\n  name: prometheus\n  namespace: monitoring\n"),
+				},
+			},
+			expect: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts, err := getNamespaceOpts(&workspaceIndex{documents: tt.documents}, nil)
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, opts.AppNamespaces)
+			assert.False(t, opts.isAppNamespace("monitoring"))
+		})
+	}
+}
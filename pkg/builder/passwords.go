@@ -0,0 +1,211 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+)
+
+// passwordsKey is the key in the helm values that holds the existingSecret and
+// existingSecretKey of each password item, by item name
+const passwordsKey = "passwords"
+
+const passwordSecretTemplate = "kots2helm-passwords-secret.yaml"
+
+// passwordEnvValueRegex matches an env var value that is only a ConfigOption, the first
+// group is the indent and the second is "- " when the value is the first key of the env var
+var passwordEnvValueRegex = regexp.MustCompile("^(\\s*)(- )?value:\\s*(['\"]?)(?:{{repl|repl{{)\\s*ConfigOption\\s+[\"`]([^\"`]+)[\"`]\\s*}}(['\"]?)\\s*$")
+
+// configOptionRegex matches a ConfigOption call, the first group is the item name
+var configOptionRegex = regexp.MustCompile("ConfigOption\\s+[\"`]([^\"`]+)[\"`]")
+
+// passwordItem is a password config item, which the chart stores in the passwords Secret
+type passwordItem struct {
+	Name string
+	// ValuesKey is the item's key in the passwords values
+	ValuesKey string
+	// ValueReference is the template expression for the item's value
+	ValueReference string
+}
+
+func isPasswordConfigItem(item kotsv1beta1.ConfigItem) bool {
+	return item.Type == "password"
+}
+
+// getPasswordItems returns the password config items, in config order
+func getPasswordItems(kotsConfig *kotsv1beta1.Config, chartName string, valuesOpts ValuesOpts) ([]passwordItem, error) {
+	passwordItems := []passwordItem{}
+
+	for _, group := range kotsConfig.Spec.Groups {
+		for _, item := range group.Items {
+			if !isPasswordConfigItem(item) {
+				continue
+			}
+
+			reference, err := getValueReferenceForConfigItem(item.Name, kotsConfig, chartName, valuesOpts)
+			if err != nil {
+				return nil, err
+			}
+
+			passwordItems = append(passwordItems, passwordItem{
+				Name:           item.Name,
+				ValuesKey:      valuesOpts.key(item.Name),
+				ValueReference: reference,
+			})
+		}
+	}
+
+	return passwordItems, nil
+}
+
+// rewritePasswordEnvValues replaces the value of env vars that are set to a password item
+// with a secretKeyRef to the passwords Secret, or to the existingSecret of the item
+func rewritePasswordEnvValues(content []byte, kotsConfig *kotsv1beta1.Config, chartName string, valuesOpts ValuesOpts) []byte {
+	if kotsConfig == nil {
+		return content
+	}
+
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		m := passwordEnvValueRegex.FindStringSubmatch(line)
+		if m == nil || m[3] != m[5] {
+			continue
+		}
+		_, item, err := findConfigItem(m[4], kotsConfig)
+		if err != nil || !isPasswordConfigItem(item) {
+			continue
+		}
+
+		indent := len(m[1]) + len(m[2])
+		if !isEnvVarValue(lines, i, indent, m[2] != "") {
+			continue
+		}
+
+		args := fmt.Sprintf(`(list $ %q %q)`, item.Name, valuesOpts.key(item.Name))
+		keyIndent := strings.Repeat(" ", indent)
+		lines[i] = strings.Join([]string{
+			fmt.Sprintf("%s%svalueFrom:", m[1], m[2]),
+			fmt.Sprintf("%s  secretKeyRef:", keyIndent),
+			fmt.Sprintf(`%s    name: {{ include "%s.passwords.secretName" %s }}`, keyIndent, chartName, args),
+			fmt.Sprintf(`%s    key: {{ include "%s.passwords.secretKey" %s }}`, keyIndent, chartName, args),
+		}, "\n")
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// getInlinedPasswordItems returns the password items that content still reads with
+// ConfigOption after rewritePasswordEnvValues. their value is inlined into the manifest, so
+// it can't come from an existingSecret
+func getInlinedPasswordItems(content []byte, kotsConfig *kotsv1beta1.Config) []string {
+	if kotsConfig == nil {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	itemNames := []string{}
+	for _, m := range configOptionRegex.FindAllStringSubmatch(string(content), -1) {
+		_, item, err := findConfigItem(m[1], kotsConfig)
+		if err != nil || !isPasswordConfigItem(item) || seen[item.Name] {
+			continue
+		}
+		seen[item.Name] = true
+		itemNames = append(itemNames, item.Name)
+	}
+
+	return itemNames
+}
+
+// isEnvVarValue is true when the value key at line idx, with its keys at indent, is in an
+// item of an env list
+func isEnvVarValue(lines []string, idx int, indent int, isItemStart bool) bool {
+	for j := idx - 1; j >= 0; j-- {
+		trimmed := strings.TrimSpace(lines[j])
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+		lineIndent := len(lines[j]) - len(strings.TrimLeft(lines[j], " "))
+
+		if !isItemStart {
+			if lineIndent >= indent {
+				// another key of the env var, or its content
+				continue
+			}
+			if lineIndent == indent-2 && strings.HasPrefix(trimmed, "- ") {
+				isItemStart = true
+				continue
+			}
+			return false
+		}
+
+		if lineIndent > indent-2 || (lineIndent == indent-2 && strings.HasPrefix(trimmed, "- ")) {
+			// another env var
+			continue
+		}
+		// the list can be at the same indent as its key
+		return trimmed == "env:"
+	}
+
+	return false
+}
+
+// createPasswordSecretTemplate will create a Secret holding the values of the password
+// config items that don't have an existingSecret, so env vars can read them with secretKeyRef
+func createPasswordSecretTemplate(workspace string, name string, index *workspaceIndex, valuesOpts ValuesOpts) error {
+	kotsConfig, err := getKOTSConfig(index)
+	if err != nil {
+		return err
+	}
+	if kotsConfig == nil {
+		return nil
+	}
+
+	passwordItems, err := getPasswordItems(kotsConfig, name, valuesOpts)
+	if err != nil {
+		return errors.Wrap(err, "failed to get password items")
+	}
+	if len(passwordItems) == 0 {
+		return nil
+	}
+
+	fileName := filepath.Join(workspace, "templates", passwordSecretTemplate)
+	if _, err := os.Stat(fileName); err == nil {
+		return errors.Errorf("templates/%s already exists in the input dir", passwordSecretTemplate)
+	}
+
+	data := []string{}
+	generated := []string{}
+	for _, passwordItem := range passwordItems {
+		hasExistingSecret := fmt.Sprintf(`dig %q %q "existingSecret" "" .Values.AsMap`, passwordsKey, passwordItem.ValuesKey)
+		data = append(data, fmt.Sprintf(`{{- if not (%s) }}
+  %s: {{ %s | b64enc }}
+{{- end }}`, hasExistingSecret, passwordItem.Name, passwordItem.ValueReference))
+		generated = append(generated, fmt.Sprintf("(not (%s))", hasExistingSecret))
+	}
+
+	// the Secret isn't created when every item is read from an existingSecret
+	secret := fmt.Sprintf(`{{- if or %s }}
+apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ include "%s.passwords.generatedSecretName" $ }}
+  labels:
+    {{- include "%s.labels" $ | nindent 4 }}
+type: Opaque
+data:
+%s
+{{- end }}
+`, strings.Join(generated, " "), name, name, strings.Join(data, "\n"))
+
+	if err := ioutil.WriteFile(fileName, []byte(secret), 0644); err != nil {
+		return errors.Wrap(err, "failed to write passwords secret")
+	}
+
+	return nil
+}
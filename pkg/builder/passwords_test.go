@@ -0,0 +1,138 @@
+package builder
+
+import (
+	"strings"
+	"testing"
+
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/replicatedhq/kots/kotskinds/multitype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_rewritePasswordEnvValues(t *testing.T) {
+	kotsConfig := &kotsv1beta1.Config{
+		Spec: kotsv1beta1.ConfigSpec{
+			Groups: []kotsv1beta1.ConfigGroup{
+				{
+					Name: "db",
+					Items: []kotsv1beta1.ConfigItem{
+						{Name: "db_password", Type: "password"},
+						{Name: "admin_password", Title: "Admin password", Type: "password", Default: multitype.FromString("changeme"), Required: true},
+						{Name: "db_host", Type: "text"},
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		expect  string
+	}{
+		{
+			name: "env value",
+			content: `env:
+  - name: DB_PASSWORD
+    value: repl{{ ConfigOption "db_password" }}`,
+			expect: `env:
+  - name: DB_PASSWORD
+    valueFrom:
+      secretKeyRef:
+        name: {{ include "app.passwords.secretName" (list $ "db_password" "db_password") }}
+        key: {{ include "app.passwords.secretKey" (list $ "db_password" "db_password") }}`,
+		},
+		{
+			name: "quoted value first",
+			content: `env:
+- value: '{{repl ConfigOption "db_password" }}'
+  name: DB_PASSWORD`,
+			expect: `env:
+- valueFrom:
+    secretKeyRef:
+      name: {{ include "app.passwords.secretName" (list $ "db_password" "db_password") }}
+      key: {{ include "app.passwords.secretKey" (list $ "db_password" "db_password") }}
+  name: DB_PASSWORD`,
+		},
+		{
+			name: "not a password",
+			content: `env:
+  - name: DB_HOST
+    value: repl{{ ConfigOption "db_host" }}`,
+			expect: `env:
+  - name: DB_HOST
+    value: repl{{ ConfigOption "db_host" }}`,
+		},
+		{
+			name: "part of the value",
+			content: `env:
+  - name: DB_URL
+    value: postgres://app:repl{{ ConfigOption "db_password" }}@db`,
+			expect: `env:
+  - name: DB_URL
+    value: postgres://app:repl{{ ConfigOption "db_password" }}@db`,
+		},
+		{
+			name: "not an env var",
+			content: `data:
+  value: repl{{ ConfigOption "db_password" }}`,
+			expect: `data:
+  value: repl{{ ConfigOption "db_password" }}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := rewritePasswordEnvValues([]byte(tt.content), kotsConfig, "app", ValuesOpts{})
+			assert.Equal(t, tt.expect, string(actual))
+		})
+	}
+
+	passwordItems, err := getPasswordItems(kotsConfig, "app", ValuesOpts{Naming: ValuesNamingCamelCase})
+	require.NoError(t, err)
+	assert.Equal(t, []passwordItem{
+		{Name: "db_password", ValuesKey: "dbPassword", ValueReference: `dig "db" "dbPassword" "" .Values.AsMap`},
+		{Name: "admin_password", ValuesKey: "adminPassword", ValueReference: `include "app.passwords.required" (list $ "adminPassword" "Admin password is required" (dig "db" "adminPassword" "" .Values.AsMap | default "changeme"))`},
+	}, passwordItems)
+
+	values := getValuesFromConfig(kotsConfig, ValuesOpts{})
+	assert.Equal(t, map[string]interface{}{
+		"db_password": map[string]interface{}{
+			"existingSecret":    "",
+			"existingSecretKey": "",
+		},
+		"admin_password": map[string]interface{}{
+			"existingSecret":    "",
+			"existingSecretKey": "",
+		},
+	}, values[passwordsKey])
+	assert.Equal(t, "", values["db"].(map[string]interface{})["admin_password"])
+}
+
+func Test_getInlinedPasswordItems(t *testing.T) {
+	kotsConfig := &kotsv1beta1.Config{
+		Spec: kotsv1beta1.ConfigSpec{
+			Groups: []kotsv1beta1.ConfigGroup{
+				{
+					Name: "db",
+					Items: []kotsv1beta1.ConfigItem{
+						{Name: "db_password", Type: "password"},
+						{Name: "db_host", Type: "text"},
+					},
+				},
+			},
+		},
+	}
+
+	content := `env:
+  - name: DB_PASSWORD
+    value: repl{{ ConfigOption "db_password" }}
+  - name: DB_URL
+    value: postgres://app:repl{{ ConfigOption "db_password" }}@repl{{ ConfigOption "db_host" }}`
+
+	rewritten := rewritePasswordEnvValues([]byte(content), kotsConfig, "app", ValuesOpts{})
+	assert.Equal(t, []string{"db_password"}, getInlinedPasswordItems(rewritten, kotsConfig))
+
+	rewritten = rewritePasswordEnvValues([]byte(content[:strings.Index(content, "  - name: DB_URL")]), kotsConfig, "app", ValuesOpts{})
+	assert.Empty(t, getInlinedPasswordItems(rewritten, kotsConfig))
+}
@@ -0,0 +1,143 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+)
+
+const randomSecretTemplate = "kots2helm-random-secret.yaml"
+
+// randomFunctionRegex matches a RandomString or RandomBytes call with a literal length and,
+// for RandomString, an optional literal charset. calls with anything else are left for the
+// unconverted report
+var randomFunctionRegex = regexp.MustCompile("(?:{{repl|repl{{)\\s*(RandomString|RandomBytes)\\s+(\\d+)(?:\\s+(\"(?:[^\"\\\\]|\\\\.)*\"|`[^`]*`))?\\s*}}")
+
+// randomConfigItem is a config item whose value or default is generated by RandomString
+// or RandomBytes. the chart generates these once and stores them in a Secret
+type randomConfigItem struct {
+	Name string
+	// ValuesReference is the template expression for the item's value in .Values
+	ValuesReference string
+	Generator       string
+	Length          int
+	// Charset is the regex that RandomString characters must match, empty for alphanumeric
+	Charset string
+	// When is the template condition for the item being shown, empty when it always is
+	When string
+}
+
+// getRandomConfigItems returns the config items that kots would generate a random value
+// for. the value takes precedence over the default, the same as it does in kots
+func getRandomConfigItems(kotsConfig *kotsv1beta1.Config, valuesOpts ValuesOpts) []randomConfigItem {
+	randomItems := []randomConfigItem{}
+
+	for _, group := range kotsConfig.Spec.Groups {
+		for _, item := range group.Items {
+			randomItem, ok := getRandomConfigItem(group, item)
+			if ok {
+				randomItem.ValuesReference = operand(configItemValuesReference(group, item, valuesOpts))
+				randomItems = append(randomItems, randomItem)
+			}
+		}
+	}
+
+	return randomItems
+}
+
+func getRandomConfigItem(group kotsv1beta1.ConfigGroup, item kotsv1beta1.ConfigItem) (randomConfigItem, bool) {
+	template, _ := configItemTemplate(item)
+
+	m := randomFunctionRegex.FindStringSubmatch(template)
+	if m == nil {
+		return randomConfigItem{}, false
+	}
+
+	length, err := strconv.Atoi(m[2])
+	if err != nil {
+		return randomConfigItem{}, false
+	}
+
+	generator := "string"
+	if m[1] == "RandomBytes" {
+		generator = "bytes"
+	}
+
+	charset := ""
+	if m[3] != "" {
+		if m[1] == "RandomBytes" {
+			return randomConfigItem{}, false
+		}
+		charset, err = strconv.Unquote(m[3])
+		if err != nil {
+			return randomConfigItem{}, false
+		}
+		if _, err := regexp.Compile(charset); err != nil {
+			return randomConfigItem{}, false
+		}
+	}
+
+	return randomConfigItem{
+		Name:      item.Name,
+		Generator: generator,
+		Length:    length,
+		Charset:   charset,
+	}, true
+}
+
+func isRandomConfigItem(group kotsv1beta1.ConfigGroup, item kotsv1beta1.ConfigItem) bool {
+	_, ok := getRandomConfigItem(group, item)
+	return ok
+}
+
+// createRandomSecretTemplate will create a Secret holding the generated values of the random
+// config items, so that they can be read back with lookup when the chart is upgraded
+func createRandomSecretTemplate(workspace string, name string, index *workspaceIndex, valuesOpts ValuesOpts) error {
+	kotsConfig, err := getKOTSConfig(index)
+	if err != nil {
+		return err
+	}
+	if kotsConfig == nil {
+		return nil
+	}
+
+	randomItems := getRandomConfigItems(kotsConfig, valuesOpts)
+	if len(randomItems) == 0 {
+		return nil
+	}
+
+	fileName := filepath.Join(workspace, "templates", randomSecretTemplate)
+	if _, err := os.Stat(fileName); err == nil {
+		return errors.Errorf("templates/%s already exists in the input dir", randomSecretTemplate)
+	}
+
+	data := []string{}
+	for _, randomItem := range randomItems {
+		// the generated value is stored even when the item is hidden
+		data = append(data, fmt.Sprintf(`  %s: {{ include "%s.random.value" (list $ %q %s %q %d %q) | b64enc }}`, randomItem.Name, name, randomItem.Name, randomItem.ValuesReference, randomItem.Generator, randomItem.Length, randomItem.Charset))
+	}
+
+	secret := fmt.Sprintf(`apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ include "%s.random.secretName" $ }}
+  labels:
+    {{- include "%s.labels" $ | nindent 4 }}
+type: Opaque
+data:
+%s
+`, name, name, strings.Join(data, "\n"))
+
+	if err := ioutil.WriteFile(fileName, []byte(secret), 0644); err != nil {
+		return errors.Wrap(err, "failed to write random secret")
+	}
+
+	return nil
+}
@@ -0,0 +1,84 @@
+package builder
+
+import (
+	"testing"
+
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/replicatedhq/kots/kotskinds/multitype"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getRandomConfigItems(t *testing.T) {
+	kotsConfig := &kotsv1beta1.Config{
+		Spec: kotsv1beta1.ConfigSpec{
+			Groups: []kotsv1beta1.ConfigGroup{
+				{
+					Name: "database",
+					Items: []kotsv1beta1.ConfigItem{
+						{
+							Name:  "db_password",
+							Type:  "password",
+							Value: multitype.FromString(`repl{{ RandomString 32 }}`),
+						},
+						{
+							Name:    "encryption_key",
+							Type:    "text",
+							Default: multitype.FromString(`{{repl RandomBytes 16 }}`),
+						},
+						{
+							Name:    "db_host",
+							Type:    "text",
+							Default: multitype.FromString("postgres"),
+						},
+						{
+							Name:    "db_name",
+							Type:    "text",
+							Default: multitype.FromString(`repl{{ RandomString 8 }}`),
+							Value:   multitype.FromString("app"),
+						},
+						{
+							Name:  "api_token",
+							Type:  "password",
+							Value: multitype.FromString(`repl{{ RandomString 16 "[a-z0-9]" }}`),
+						},
+						{
+							// a charset that isn't a literal is reported as unconverted
+							Name:  "session_key",
+							Type:  "password",
+							Value: multitype.FromString(`repl{{ RandomString 16 (ConfigOption "charset") }}`),
+						},
+						{
+							Name:  "bad_charset",
+							Type:  "password",
+							Value: multitype.FromString(`repl{{ RandomString 16 "[a-z" }}`),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	expect := []randomConfigItem{
+		{
+			Name:            "db_password",
+			ValuesReference: `(dig "database" "db_password" "" .Values.AsMap)`,
+			Generator:       "string",
+			Length:          32,
+		},
+		{
+			Name:            "encryption_key",
+			ValuesReference: `(dig "database" "encryption_key" "" .Values.AsMap)`,
+			Generator:       "bytes",
+			Length:          16,
+		},
+		{
+			Name:            "api_token",
+			ValuesReference: `(dig "database" "api_token" "" .Values.AsMap)`,
+			Generator:       "string",
+			Length:          16,
+			Charset:         "[a-z0-9]",
+		},
+	}
+
+	assert.Equal(t, expect, getRandomConfigItems(kotsConfig, ValuesOpts{}))
+}
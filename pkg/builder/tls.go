@@ -0,0 +1,291 @@
+package builder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/replicatedhq/kots2helm/pkg/logger"
+)
+
+const tlsSecretTemplate = "kots2helm-tls-secret.yaml"
+
+var (
+	// tlsFunctionRegex matches a kots tls function name in a template action. the longer
+	// names are first so that TLSCertFromCA isn't matched as TLSCert
+	tlsFunctionRegex   = regexp.MustCompile(`(^|[^.\w"])(TLSCertFromCA|TLSKeyFromCA|TLSCACert|TLSCAKey|TLSCert|TLSKey)\s+`)
+	replDelimiterRegex = regexp.MustCompile(`^(?:{{repl|repl{{)\s*`)
+	// templateVariableRegex matches a template variable other than the root context
+	templateVariableRegex = regexp.MustCompile(`\$\w`)
+)
+
+// replaceTLSFunctions converts the kots tls functions to the named templates in _helpers.tpl.
+// the templates generate the material once per name for each render, so every manifest that
+// uses a name gets the same certificate
+func replaceTLSFunctions(content []byte, chartName string) ([]byte, error) {
+	updatedContent := templateActionRegex.ReplaceAllStringFunc(string(content), func(action string) string {
+		return replaceTLSFunctionsInAction(action, chartName)
+	})
+
+	return []byte(updatedContent), nil
+}
+
+func replaceTLSFunctionsInAction(action string, chartName string) string {
+	prefixEnd := 0
+	if m := replDelimiterRegex.FindStringIndex(action); m != nil {
+		prefixEnd = m[1]
+	}
+
+	updated := ""
+	rest := action
+	offset := 0
+	for {
+		m := tlsFunctionRegex.FindStringSubmatchIndex(rest)
+		if m == nil {
+			break
+		}
+
+		functionStart := m[4]
+		function := rest[m[4]:m[5]]
+		argsStart := m[1]
+		args, argsEnd := scanTemplateArgs(rest, argsStart)
+
+		expr, ok := tlsFunctionInclude(function, args, chartName)
+		if !ok {
+			updated += rest[:argsEnd]
+			rest = rest[argsEnd:]
+			offset += argsEnd
+			continue
+		}
+
+		if prefixEnd > 0 && offset+functionStart == prefixEnd {
+			// the function starts the statement. the kots delimiter is converted once the
+			// rest of the statement has been
+			updated = action[:prefixEnd] + expr
+		} else if functionStart > 0 && rest[functionStart-1] == '(' && argsEnd < len(rest) && rest[argsEnd] == ')' {
+			// already in parens
+			updated += rest[:functionStart] + expr
+		} else {
+			updated += rest[:functionStart] + "(" + expr + ")"
+		}
+
+		rest = rest[argsEnd:]
+		offset += argsEnd
+		if rest != "" && rest[0] != ' ' && rest[0] != ')' {
+			updated += " "
+		}
+	}
+
+	return updated + rest
+}
+
+// tlsFunctionInclude returns the include for a kots tls function and its args. the list
+// passed to the named template is the root context, the kind of material, the ca name,
+// the cert name and then the remaining args
+func tlsFunctionInclude(function string, args string, chartName string) (string, bool) {
+	fields := splitTemplateArgs(args)
+
+	var template, kind, caName, certName string
+	var rest []string
+
+	switch function {
+	case "TLSCert", "TLSKey":
+		if len(fields) < 1 {
+			return "", false
+		}
+		kind, caName, certName, rest = "cert", `""`, fields[0], fields[1:]
+	case "TLSCACert", "TLSCAKey":
+		if len(fields) < 1 {
+			return "", false
+		}
+		kind, caName, certName, rest = "ca", fields[0], `""`, fields[1:]
+	case "TLSCertFromCA", "TLSKeyFromCA":
+		if len(fields) < 2 {
+			return "", false
+		}
+		kind, caName, certName, rest = "certFromCA", fields[0], fields[1], fields[2:]
+	default:
+		return "", false
+	}
+
+	switch function {
+	case "TLSCert", "TLSCertFromCA":
+		template = "tlsCert"
+	case "TLSKey", "TLSKeyFromCA":
+		template = "tlsKey"
+	case "TLSCACert":
+		template = "tlsCACert"
+	case "TLSCAKey":
+		template = "tlsCAKey"
+	}
+
+	list := append([]string{"list", "$", fmt.Sprintf("%q", kind), caName, certName}, rest...)
+
+	return fmt.Sprintf(`include "%s.%s" (%s)`, chartName, template, strings.Join(list, " ")), true
+}
+
+// scanTemplateArgs returns the arguments of a template function that start at start in s,
+// stopping at a pipe, an unmatched closing paren or the end of the action
+func scanTemplateArgs(s string, start int) (string, int) {
+	depth := 0
+	i := start
+	for i < len(s) {
+		c := s[i]
+		switch {
+		case c == '"' || c == '`':
+			i = skipTemplateString(s, i)
+			continue
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth == 0 {
+				return strings.TrimSpace(s[start:i]), i
+			}
+			depth--
+		case c == '|' && depth == 0:
+			return strings.TrimSpace(s[start:i]), i
+		case strings.HasPrefix(s[i:], "}}") && depth == 0:
+			// keep the whitespace before the closing delimiter
+			end := i
+			for end > start && s[end-1] == ' ' {
+				end--
+			}
+			return strings.TrimSpace(s[start:end]), end
+		}
+		i++
+	}
+
+	return strings.TrimSpace(s[start:]), len(s)
+}
+
+// splitTemplateArgs splits template function args on spaces, keeping quoted strings
+// and parenthesized expressions together
+func splitTemplateArgs(args string) []string {
+	fields := []string{}
+	depth := 0
+	start := -1
+	i := 0
+	for i < len(args) {
+		c := args[i]
+		if start == -1 && c != ' ' {
+			start = i
+		}
+		switch {
+		case c == '"' || c == '`':
+			i = skipTemplateString(args, i)
+			continue
+		case c == '(':
+			depth++
+		case c == ')':
+			depth--
+		case c == ' ' && depth == 0 && start != -1:
+			fields = append(fields, args[start:i])
+			start = -1
+		}
+		i++
+	}
+	if start != -1 {
+		fields = append(fields, args[start:])
+	}
+
+	return fields
+}
+
+// skipTemplateString returns the index after the quoted string that starts at i
+func skipTemplateString(s string, i int) int {
+	quote := s[i]
+	for j := i + 1; j < len(s); j++ {
+		if quote == '"' && s[j] == '\\' {
+			j++
+			continue
+		}
+		if s[j] == quote {
+			return j + 1
+		}
+	}
+
+	return len(s)
+}
+
+// createTLSSecretTemplate will create a Secret holding the material generated by the tls
+// named templates, so that it can be looked up and reused on upgrade. the Secret template
+// generates every certificate used in the chart with the same args, so the material is
+// the same no matter which order helm renders the templates in
+func createTLSSecretTemplate(workspace string, name string) error {
+	templatesDir := filepath.Join(workspace, "templates")
+	fileName := filepath.Join(templatesDir, tlsSecretTemplate)
+
+	includeRegex := regexp.MustCompile(fmt.Sprintf(`include "%s\.(?:tlsCert|tlsKey|tlsCACert|tlsCAKey)" \(`, regexp.QuoteMeta(name)))
+
+	calls := []string{}
+	seen := map[string]bool{}
+
+	err := filepath.Walk(templatesDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || path == fileName {
+			return nil
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range includeRegex.FindAllStringIndex(string(content), -1) {
+			args, end := scanTemplateArgs(string(content), m[1])
+			if end >= len(content) || content[end] != ')' {
+				continue
+			}
+
+			call := fmt.Sprintf(`{{- $_ := include "%s.tls.generate" (%s) }}`, name, args)
+			if templateVariableRegex.MatchString(args) {
+				logger.Warnf("%s uses template variables for tls args, its certificate will not be stored for upgrades", path)
+				continue
+			}
+			if !seen[call] {
+				seen[call] = true
+				calls = append(calls, call)
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return errors.Wrap(err, "failed to find tls functions")
+	}
+
+	if len(calls) == 0 {
+		return nil
+	}
+
+	if _, err := os.Stat(fileName); err == nil {
+		return errors.Errorf("templates/%s already exists in the input dir", tlsSecretTemplate)
+	}
+
+	secret := fmt.Sprintf(`%s
+apiVersion: v1
+kind: Secret
+metadata:
+  name: {{ include "%s.tls.secretName" $ }}
+  labels:
+    {{- include "%s.labels" $ | nindent 4 }}
+type: Opaque
+data:
+{{- range $key, $material := (get $ "kots2helmTLS") }}
+  {{ $key }}.crt: {{ $material.cert | b64enc }}
+  {{ $key }}.key: {{ $material.key | b64enc }}
+{{- end }}
+`, strings.Join(calls, "\n"), name, name)
+
+	if err := ioutil.WriteFile(fileName, []byte(secret), 0644); err != nil {
+		return errors.Wrap(err, "failed to write tls secret")
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_replaceTLSFunctions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		expect  string
+	}{
+		{
+			name:    "no tls functions",
+			content: `cert: {{ .Values.group1.cert }}`,
+			expect:  `cert: {{ .Values.group1.cert }}`,
+		},
+		{
+			name:    "TLSCert statement",
+			content: `tls.crt: '{{repl TLSCert "api" "api.example.com" (list "10.0.0.1") (list "api") 365 | Base64Encode }}'`,
+			expect:  `tls.crt: '{{ include "app.tlsCert" (list $ "cert" "" "api" "api.example.com" (list "10.0.0.1") (list "api") 365) | b64enc }}'`,
+		},
+		{
+			name:    "TLSCACert with an unconverted arg",
+			content: `ca.crt: '{{repl TLSCACert (LicenseFieldValue "ca") | Base64Encode }}'`,
+			expect:  `ca.crt: '{{repl include "app.tlsCACert" (list $ "ca" (LicenseFieldValue "ca") "") | Base64Encode }}'`,
+		},
+		{
+			name:    "TLSKey with only a name",
+			content: `tls.key: repl{{ TLSKey "api" }}`,
+			expect:  `tls.key: {{ include "app.tlsKey" (list $ "cert" "" "api") }}`,
+		},
+		{
+			name:    "TLSCACert and TLSCertFromCA",
+			content: `ca.crt: repl{{ TLSCACert "ca" 30 }} crt: repl{{ TLSCertFromCA "ca" "internal" "internal.svc" }}`,
+			expect:  `ca.crt: {{ include "app.tlsCACert" (list $ "ca" "ca" "" 30) }} crt: {{ include "app.tlsCert" (list $ "certFromCA" "ca" "internal" "internal.svc") }}`,
+		},
+		{
+			name:    "in an expression",
+			content: `{{repl if eq (TLSCert "api") "" }}`,
+			expect:  `{{ if eq (include "app.tlsCert" (list $ "cert" "" "api")) "" }}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+			actual, err := replaceTLSFunctions([]byte(tt.content), "app")
+			req.NoError(err)
+			assert.Equal(t, tt.expect, string(convertStatementDelimiters(actual)))
+		})
+	}
+}
@@ -0,0 +1,210 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/pkg/errors"
+)
+
+// UnconvertedPolicy controls what happens to kots template functions that
+// could not be converted to helm templates
+type UnconvertedPolicy string
+
+const (
+	// UnconvertedPolicyComment wraps each remaining function in a helm comment
+	// and a fail call so the chart reports what needs manual work
+	UnconvertedPolicyComment UnconvertedPolicy = "comment"
+	// UnconvertedPolicyFail fails the build without packaging a chart
+	UnconvertedPolicyFail UnconvertedPolicy = "fail"
+	// UnconvertedPolicyKeep leaves the remaining functions in the templates
+	UnconvertedPolicyKeep UnconvertedPolicy = "keep"
+)
+
+var (
+	unconvertedTemplateFunctionRegex = regexp.MustCompile(`(?:{{repl|repl{{)\s+.*?}}`)
+	yamlKeyRegex                     = regexp.MustCompile(`^("[^"]*"|'[^']*'|[^\s:#{}\[\],]+)\s*:(?:\s|$)`)
+)
+
+func parseUnconvertedPolicy(s string) (UnconvertedPolicy, error) {
+	switch UnconvertedPolicy(s) {
+	case "":
+		return UnconvertedPolicyKeep, nil
+	case UnconvertedPolicyComment, UnconvertedPolicyFail, UnconvertedPolicyKeep:
+		return UnconvertedPolicy(s), nil
+	}
+
+	return "", errors.Errorf("unknown unconverted policy %q, must be one of comment, fail or keep", s)
+}
+
+// commentUnconvertedTemplateFunctions replaces every remaining kots template function
+// in content with a helm comment holding the original expression, followed by a fail
+// call that names the file and yaml path needing manual conversion
+func commentUnconvertedTemplateFunctions(filename string, content []byte) []byte {
+	lines := strings.Split(string(content), "\n")
+
+	for i, line := range lines {
+		if !unconvertedTemplateFunctionRegex.MatchString(line) {
+			continue
+		}
+
+		yamlPath := yamlPathForLine(lines, i)
+		lines[i] = unconvertedTemplateFunctionRegex.ReplaceAllStringFunc(line, func(expr string) string {
+			location := filename
+			if yamlPath != "" {
+				location = fmt.Sprintf("%s: %s", filename, yamlPath)
+			}
+			message := fmt.Sprintf("%s: %s could not be converted to a helm template and needs manual conversion", location, expr)
+
+			return fmt.Sprintf(`{{/* kots2helm: %s */}}{{ fail %q }}`, strings.ReplaceAll(expr, "*/", "* /"), message)
+		})
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// yamlPathForLine makes a best effort at finding the path to the value on line idx,
+// using indentation to find the parent keys. list items are shown as []
+func yamlPathForLine(lines []string, idx int) string {
+	keys := []string{}
+	indent := -1
+
+	for i := idx; i >= 0; i-- {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " ")
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		lineIndent := len(line) - len(trimmed)
+		if i != idx && lineIndent >= indent {
+			continue
+		}
+
+		isListItem := strings.HasPrefix(trimmed, "- ")
+		trimmed = strings.TrimPrefix(trimmed, "- ")
+
+		key := ""
+		if m := yamlKeyRegex.FindStringSubmatch(trimmed); m != nil {
+			key = strings.Trim(m[1], `"'`)
+		}
+
+		if i == idx {
+			if key != "" {
+				keys = append(keys, key)
+			}
+			if isListItem {
+				keys = append(keys, "[]")
+			}
+			indent = lineIndent
+			continue
+		}
+
+		if isListItem {
+			// the line is in the value of the item's key when it's indented past it
+			if key != "" && indent > lineIndent+2 {
+				keys = append(keys, key)
+			}
+			keys = append(keys, "[]")
+		} else if key != "" {
+			keys = append(keys, key)
+		} else {
+			// template actions and other lines without a key don't change the path
+			continue
+		}
+		indent = lineIndent
+	}
+
+	path := ""
+	for i := len(keys) - 1; i >= 0; i-- {
+		if keys[i] == "[]" {
+			path += "[]"
+		} else if path == "" {
+			path = keys[i]
+		} else {
+			path += "." + keys[i]
+		}
+	}
+
+	return path
+}
+
+// kotsFunctionAliases are kots template functions that are the same as a sprig function
+// with another name
+var kotsFunctionAliases = map[string]string{
+	"Base64Encode": "b64enc",
+	"Base64Decode": "b64dec",
+}
+
+// helmFunctionNames are the functions and keywords that helm templates can use
+var helmFunctionNames = func() map[string]bool {
+	names := map[string]bool{}
+	for name := range sprig.TxtFuncMap() {
+		names[name] = true
+	}
+	for _, name := range []string{
+		// text/template keywords and builtins
+		"if", "else", "end", "range", "with", "define", "template", "block", "break", "continue",
+		"nil", "true", "false", "and", "or", "not", "len", "index", "slice", "print", "printf",
+		"println", "html", "js", "urlquery", "call", "eq", "ne", "lt", "le", "gt", "ge",
+		// added by the helm engine
+		"include", "tpl", "required", "lookup", "toYaml", "fromYaml", "fromYamlArray",
+		"toJson", "fromJson", "fromJsonArray", "toToml",
+	} {
+		names[name] = true
+	}
+	return names
+}()
+
+// convertStatementDelimiters replaces the repl delimiter of each kots template action that
+// only calls helm functions once the kots functions in it have been converted. the kots
+// functions with a sprig alias are renamed. an action that still calls a function helm
+// doesn't have keeps its delimiter, so it's in the unconverted report and --strict fails
+func convertStatementDelimiters(content []byte) []byte {
+	return []byte(templateActionRegex.ReplaceAllStringFunc(string(content), convertStatementDelimiter))
+}
+
+func convertStatementDelimiter(action string) string {
+	m := replDelimiterRegex.FindStringIndex(action)
+	if m == nil {
+		return action
+	}
+
+	body := action[m[1]:]
+	converted := ""
+	unconverted := false
+	for i := 0; i < len(body); {
+		c := body[i]
+		switch {
+		case c == '"' || c == '`' || c == '\'':
+			end := skipTemplateString(body, i)
+			converted += body[i:end]
+			i = end
+		case c == '_' || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c)):
+			end := i
+			for end < len(body) && (body[end] == '_' || unicode.IsLetter(rune(body[end])) || unicode.IsDigit(rune(body[end]))) {
+				end++
+			}
+			word := body[i:end]
+			isFunction := !unicode.IsDigit(rune(c)) && (i == 0 || (body[i-1] != '.' && body[i-1] != '$'))
+			if alias, ok := kotsFunctionAliases[word]; ok && isFunction {
+				word = alias
+			} else if isFunction && !helmFunctionNames[word] {
+				unconverted = true
+			}
+			converted += word
+			i = end
+		default:
+			converted += string(c)
+			i++
+		}
+	}
+
+	if unconverted {
+		return action
+	}
+	return "{{ " + converted
+}
@@ -0,0 +1,124 @@
+package builder
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_yamlPathForLine(t *testing.T) {
+	content := []string{
+		"apiVersion: apps/v1",
+		"kind: Deployment",
+		"spec:",
+		"  template:",
+		"    spec:",
+		"{{ if .Values.isKurl }}",
+		"      containers:",
+		"        - name: api",
+		"          env:",
+		"            - name: LICENSE_ID",
+		"              value: repl{{ LicenseFieldValue \"licenseID\" }}",
+		"          envFrom:",
+		"            - secretRef:",
+		"                name: api",
+		"{{ end }}",
+	}
+	tests := []struct {
+		name   string
+		idx    int
+		expect string
+	}{
+		{
+			name:   "top level key",
+			idx:    1,
+			expect: "kind",
+		},
+		{
+			name:   "nested list item value",
+			idx:    10,
+			expect: "spec.template.spec.containers[].env[].value",
+		},
+		{
+			name:   "list item line",
+			idx:    9,
+			expect: "spec.template.spec.containers[].env[].name",
+		},
+		{
+			name:   "in the value of a list item key",
+			idx:    13,
+			expect: "spec.template.spec.containers[].envFrom[].secretRef.name",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expect, yamlPathForLine(content, tt.idx))
+		})
+	}
+}
+
+func Test_commentUnconvertedTemplateFunctions(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		expect  string
+	}{
+		{
+			name:    "nothing to comment",
+			content: `name: {{ .Values.group1.foo }}`,
+			expect:  `name: {{ .Values.group1.foo }}`,
+		},
+		{
+			name: "remaining function",
+			content: `metadata:
+  name: repl{{ LicenseFieldValue "appSlug" }}`,
+			expect: `metadata:
+  name: {{/* kots2helm: repl{{ LicenseFieldValue "appSlug" }} */}}{{ fail "templates/a.yaml: metadata.name: repl{{ LicenseFieldValue \"appSlug\" }} could not be converted to a helm template and needs manual conversion" }}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := commentUnconvertedTemplateFunctions("templates/a.yaml", []byte(tt.content))
+			assert.Equal(t, tt.expect, string(actual))
+		})
+	}
+}
+
+func Test_convertStatementDelimiters(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		expect  string
+	}{
+		{
+			name:    "helm functions only",
+			content: `value: repl{{ .Values.proxy.httpProxy | default "" }}`,
+			expect:  `value: {{ .Values.proxy.httpProxy | default "" }}`,
+		},
+		{
+			name:    "kots function with a sprig alias",
+			content: `value: '{{repl include "app.tlsCert" (list $ "cert" "" "api") | Base64Encode }}'`,
+			expect:  `value: '{{ include "app.tlsCert" (list $ "cert" "" "api") | b64enc }}'`,
+		},
+		{
+			name:    "unconverted kots function",
+			content: `value: '{{repl .Values.isKurl | and (LicenseFieldValue "kurl") | Base64Encode }}'`,
+			expect:  `value: '{{repl .Values.isKurl | and (LicenseFieldValue "kurl") | Base64Encode }}'`,
+		},
+		{
+			name:    "function names in strings and fields",
+			content: `value: repl{{ .Values.Base64Encode | default "LicenseFieldValue" }}`,
+			expect:  `value: {{ .Values.Base64Encode | default "LicenseFieldValue" }}`,
+		},
+		{
+			name:    "helm template",
+			content: `value: {{ .Values.name }}`,
+			expect:  `value: {{ .Values.name }}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expect, string(convertStatementDelimiters([]byte(tt.content))))
+		})
+	}
+}
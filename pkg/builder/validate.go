@@ -0,0 +1,199 @@
+package builder
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+	"helm.sh/helm/v3/pkg/action"
+	"helm.sh/helm/v3/pkg/chart/loader"
+	"helm.sh/helm/v3/pkg/chartutil"
+	"helm.sh/helm/v3/pkg/engine"
+	"helm.sh/helm/v3/pkg/lint/support"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+var (
+	templateErrorFileRegex = regexp.MustCompile(`(?:template: |\()[^/:()\s]+/(templates/[^:()\s]+):`)
+)
+
+// ValidationFailure is a problem found in the chart, reported against the file
+// in the input dir that it was converted from
+type ValidationFailure struct {
+	SourceFile string
+	Message    string
+}
+
+func (f ValidationFailure) String() string {
+	return fmt.Sprintf("%s: %s", f.SourceFile, f.Message)
+}
+
+// validateHelmChart runs helm lint and renders the chart in workspace with the default
+// values, checking that every rendered document is valid yaml that kubernetes can decode.
+// in strict mode, lint warnings are failures too
+func validateHelmChart(workspace string, inputDir string, strict bool) error {
+	failures := []ValidationFailure{}
+
+	failures = append(failures, lintHelmChart(workspace, inputDir, strict)...)
+
+	renderFailures, err := renderHelmChart(workspace, inputDir)
+	if err != nil {
+		return errors.Wrap(err, "failed to render chart")
+	}
+	failures = append(failures, renderFailures...)
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(failures, func(i, j int) bool {
+		return failures[i].SourceFile < failures[j].SourceFile
+	})
+
+	// lint renders the templates too, so the same template error can be reported twice
+	reported := map[string]bool{}
+
+	fmt.Println("The chart failed validation:")
+	for _, failure := range failures {
+		if reported[failure.String()] {
+			continue
+		}
+		reported[failure.String()] = true
+		fmt.Printf("%s\n", failure)
+	}
+
+	return errors.Errorf("chart failed validation with %d errors", len(reported))
+}
+
+func lintHelmChart(workspace string, inputDir string, strict bool) []ValidationFailure {
+	client := action.NewLint()
+	client.Strict = strict
+
+	lowestTolerance := support.ErrorSev
+	if strict {
+		lowestTolerance = support.WarningSev
+	}
+
+	result := client.Run([]string{workspace}, map[string]interface{}{})
+
+	failures := []ValidationFailure{}
+	for _, msg := range result.Messages {
+		if msg.Severity < lowestTolerance {
+			continue
+		}
+
+		failures = append(failures, ValidationFailure{
+			SourceFile: sourceFileForTemplateError(msg.Err, sourceFileForChartFile(msg.Path, inputDir), inputDir),
+			Message:    msg.Err.Error(),
+		})
+	}
+	if result.TotalChartsLinted == 0 {
+		for _, err := range result.Errors {
+			failures = append(failures, ValidationFailure{
+				SourceFile: workspace,
+				Message:    err.Error(),
+			})
+		}
+	}
+
+	return failures
+}
+
+// renderHelmChart renders the chart with its default values. the engine is in lint
+// mode so that fail and required guards, which depend on user supplied values, don't
+// stop the render
+func renderHelmChart(workspace string, inputDir string) ([]ValidationFailure, error) {
+	chart, err := loader.Load(workspace)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load chart")
+	}
+
+	options := chartutil.ReleaseOptions{
+		Name:      chart.Name(),
+		Namespace: "default",
+		Revision:  1,
+		IsInstall: true,
+	}
+	renderValues, err := chartutil.ToRenderValues(chart, map[string]interface{}{}, options, chartutil.DefaultCapabilities)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create render values")
+	}
+
+	e := engine.Engine{LintMode: true}
+	rendered, err := e.Render(chart, renderValues)
+	if err != nil {
+		return []ValidationFailure{
+			{
+				SourceFile: sourceFileForTemplateError(err, inputDir, inputDir),
+				Message:    err.Error(),
+			},
+		}, nil
+	}
+
+	decode := scheme.Codecs.UniversalDeserializer().Decode
+
+	failures := []ValidationFailure{}
+	for name, content := range rendered {
+		base := path.Base(name)
+		if strings.HasPrefix(base, "_") || base == "NOTES.txt" {
+			continue
+		}
+
+		chartFile := strings.TrimPrefix(name, chart.Name()+"/")
+		sourceFile := sourceFileForChartFile(chartFile, inputDir)
+
+		for i, doc := range yamlDocumentSeparatorRegex.Split(content, -1) {
+			if isEmptyYAMLDocument(doc) {
+				continue
+			}
+
+			parsed := map[string]interface{}{}
+			if err := yaml.Unmarshal([]byte(doc), &parsed); err != nil {
+				failures = append(failures, ValidationFailure{
+					SourceFile: sourceFile,
+					Message:    fmt.Sprintf("document %d is not valid yaml: %s", i, err),
+				})
+				continue
+			}
+
+			if _, _, err := decode([]byte(doc), nil, nil); err != nil && !runtime.IsNotRegisteredError(err) {
+				failures = append(failures, ValidationFailure{
+					SourceFile: sourceFile,
+					Message:    fmt.Sprintf("document %d is not a valid kubernetes object: %s", i, err),
+				})
+			}
+		}
+	}
+
+	return failures, nil
+}
+
+// sourceFileForChartFile maps a path relative to the chart root back to the
+// file in the input dir that it was copied from
+func sourceFileForChartFile(chartFile string, inputDir string) string {
+	if strings.HasPrefix(chartFile, "templates/") {
+		return filepath.Join(inputDir, strings.TrimPrefix(chartFile, "templates/"))
+	}
+	if strings.HasPrefix(chartFile, "crds/") {
+		return filepath.Join(inputDir, strings.TrimPrefix(chartFile, "crds/"))
+	}
+
+	return chartFile
+}
+
+// sourceFileForTemplateError finds the template named in a helm template error
+// and maps it back to the input dir, returning fallback if there isn't one
+func sourceFileForTemplateError(err error, fallback string, inputDir string) string {
+	m := templateErrorFileRegex.FindStringSubmatch(err.Error())
+	if m == nil {
+		return fallback
+	}
+
+	return sourceFileForChartFile(m[1], inputDir)
+}
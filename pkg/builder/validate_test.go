@@ -0,0 +1,135 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_sourceFileForTemplateError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		expect string
+	}{
+		{
+			name:   "parse error",
+			err:    errors.New(`parse error at (sentry/templates/deploy.yaml:21): function "LicenseFieldValue" not defined`),
+			expect: "manifests/deploy.yaml",
+		},
+		{
+			name:   "execute error in a subdirectory",
+			err:    errors.New(`template: sentry/templates/web/deploy.yaml:20:14: executing "sentry/templates/web/deploy.yaml" at <.Values.foo.bar>: nil pointer evaluating interface {}.bar`),
+			expect: "manifests/web/deploy.yaml",
+		},
+		{
+			name:   "no template in the error",
+			err:    errors.New(`chart metadata is missing these dependencies`),
+			expect: "manifests",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expect, sourceFileForTemplateError(tt.err, "manifests", "manifests"))
+		})
+	}
+}
+
+func Test_validateHelmChart(t *testing.T) {
+	tests := []struct {
+		name              string
+		template          string
+		wantErr           bool
+		wantStrictErr     bool
+		wantRenderFailure string
+	}{
+		{
+			name: "valid",
+			template: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  key: {{ .Values.key | quote }}
+`,
+		},
+		{
+			name: "broken template",
+			template: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  key: {{ .Values.key | nosuchfunction }}
+`,
+			wantErr:           true,
+			wantStrictErr:     true,
+			wantRenderFailure: `function "nosuchfunction" not defined`,
+		},
+		{
+			name: "invalid yaml",
+			template: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: app
+data:
+  key: [{{ .Values.key }}
+`,
+			wantErr:           true,
+			wantStrictErr:     true,
+			wantRenderFailure: "document 0 is not valid yaml",
+		},
+		{
+			// lint warns about names that kubernetes would reject
+			name: "lint warning",
+			template: `apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: App_Config
+data:
+  key: {{ .Values.key | quote }}
+`,
+			wantErr:       false,
+			wantStrictErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := require.New(t)
+
+			workspace := t.TempDir()
+			req.NoError(os.MkdirAll(filepath.Join(workspace, "templates"), 0755))
+			req.NoError(os.WriteFile(filepath.Join(workspace, "Chart.yaml"), []byte("apiVersion: v2\nname: app\nversion: 0.0.1\n"), 0644))
+			req.NoError(os.WriteFile(filepath.Join(workspace, "values.yaml"), []byte("key: value\n"), 0644))
+			req.NoError(os.WriteFile(filepath.Join(workspace, "templates", "configmap.yaml"), []byte(tt.template), 0644))
+
+			failures, err := renderHelmChart(workspace, "manifests")
+			req.NoError(err)
+			if tt.wantRenderFailure == "" {
+				assert.Empty(t, failures)
+			} else {
+				req.Len(failures, 1)
+				assert.Equal(t, filepath.Join("manifests", "configmap.yaml"), failures[0].SourceFile)
+				assert.Contains(t, failures[0].Message, tt.wantRenderFailure)
+			}
+
+			err = validateHelmChart(workspace, "manifests", false)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+
+			err = validateHelmChart(workspace, "manifests", true)
+			if tt.wantStrictErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
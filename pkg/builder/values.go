@@ -1,9 +1,11 @@
 package builder
 
 import (
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/pkg/errors"
 	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
@@ -12,30 +14,33 @@ import (
 
 // createValuesYAML will convert the config.yaml to a values.yaml and put it in the root
 // of workspace. This function
-func createValuesYAML(workspace string) error {
-	objP, err := getKOTSKind(workspace, "kots.io", "v1beta1", "Config")
+func createValuesYAML(workspace string, index *workspaceIndex, valuesOpts ValuesOpts) error {
+	kotsConfig, err := getKOTSConfig(index)
 	if err != nil {
 		return errors.Wrap(err, "failed to get config")
 	}
-	if objP == nil {
+	if kotsConfig == nil {
 		fmt.Printf("no kots config found\n")
-		return nil
+		kotsConfig = &kotsv1beta1.Config{}
 	}
 
-	obj := *objP
-	kotsConfig := obj.(*kotsv1beta1.Config)
-	values := map[string]interface{}{}
+	values := getValuesFromConfig(kotsConfig, valuesOpts)
 
-	// always present
-	values["isKurl"] = false
+	kurlValues, err := getKurlValues(index)
+	if err != nil {
+		return errors.Wrap(err, "failed to get kurl values")
+	}
+	values["kurl"] = kurlValues
 
-	for _, configGroup := range kotsConfig.Spec.Groups {
-		valuesGroup := map[string]interface{}{}
-		for _, configItem := range configGroup.Items {
-			valuesGroup[configItem.Name] = configItem.Default
+	additionalNamespaces, err := getAdditionalNamespaces(index)
+	if err != nil {
+		return errors.Wrap(err, "failed to get additional namespaces")
+	}
+	if len(additionalNamespaces) > 0 {
+		values["additionalNamespaces"] = map[string]interface{}{
+			"create": true,
+			"names":  additionalNamespaces,
 		}
-
-		values[configGroup.Name] = valuesGroup
 	}
 
 	rendered, err := yaml.Marshal(values)
@@ -51,3 +56,320 @@ func createValuesYAML(workspace string) error {
 
 	return nil
 }
+
+// createValuesSchema will create a values.schema.json describing the values created
+// from the config.yaml and put it in the root of workspace
+func createValuesSchema(workspace string, index *workspaceIndex, valuesOpts ValuesOpts) error {
+	kotsConfig, err := getKOTSConfig(index)
+	if err != nil {
+		return errors.Wrap(err, "failed to get config")
+	}
+	if kotsConfig == nil {
+		kotsConfig = &kotsv1beta1.Config{}
+	}
+
+	values := getValuesFromConfig(kotsConfig, valuesOpts)
+
+	properties := map[string]interface{}{
+		"nameOverride": map[string]interface{}{
+			"type": "string",
+		},
+		"fullnameOverride": map[string]interface{}{
+			"type": "string",
+		},
+		"isKurl": map[string]interface{}{
+			"type": schemaTypeForValue(values["isKurl"]),
+		},
+		"kurl": map[string]interface{}{
+			"type":        "object",
+			"description": "kurl installer add-on settings, used by KurlBool, KurlInt, KurlString and KurlOption",
+		},
+		"isAirgap": map[string]interface{}{
+			"type": "boolean",
+		},
+		"distribution": map[string]interface{}{
+			"type":        "string",
+			"description": "the kubernetes distribution, detected when empty",
+		},
+		"kotsVersion": map[string]interface{}{
+			"type":        "string",
+			"description": "the kots version used by KotsVersion. it must be set when a template uses KotsVersion",
+		},
+		"proxy": map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"httpProxy": map[string]interface{}{
+					"type": "string",
+				},
+				"httpsProxy": map[string]interface{}{
+					"type": "string",
+				},
+				"noProxy": map[string]interface{}{
+					"type": "string",
+				},
+			},
+		},
+	}
+
+	additionalNamespaces, err := getAdditionalNamespaces(index)
+	if err != nil {
+		return errors.Wrap(err, "failed to get additional namespaces")
+	}
+	if len(additionalNamespaces) > 0 {
+		properties["additionalNamespaces"] = map[string]interface{}{
+			"type":        "object",
+			"description": "the additionalNamespaces of the kots application, image pull secrets are copied into them",
+			"properties": map[string]interface{}{
+				"create": map[string]interface{}{
+					"type":        "boolean",
+					"description": "create the namespaces with the chart",
+				},
+				"names": map[string]interface{}{
+					"type": "array",
+					"items": map[string]interface{}{
+						"type": "string",
+					},
+				},
+			},
+		}
+	}
+
+	if filenames, ok := values[configFilenamesKey].(map[string]interface{}); ok {
+		filenameProperties := map[string]interface{}{}
+		for name := range filenames {
+			filenameProperties[name] = map[string]interface{}{
+				"type": "string",
+			}
+		}
+		properties[configFilenamesKey] = map[string]interface{}{
+			"type":        "object",
+			"description": "the filenames of the file config items",
+			"properties":  filenameProperties,
+		}
+	}
+
+	if passwords, ok := values[passwordsKey].(map[string]interface{}); ok {
+		passwordProperties := map[string]interface{}{}
+		for name := range passwords {
+			passwordProperties[name] = map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"existingSecret": map[string]interface{}{
+						"type":        "string",
+						"description": "a Secret to read the password from, instead of the one the chart creates",
+					},
+					"existingSecretKey": map[string]interface{}{
+						"type":        "string",
+						"description": "the key of the password in the existingSecret, the item name by default",
+					},
+				},
+			}
+		}
+		properties[passwordsKey] = map[string]interface{}{
+			"type":        "object",
+			"description": "the Secrets that hold the password config items",
+			"properties":  passwordProperties,
+		}
+	}
+
+	for _, configGroup := range kotsConfig.Spec.Groups {
+		if groupKey, ok := valuesOpts.groupKey(configGroup); ok {
+			groupSchema := schemaObjectAt(properties, []string{groupKey})
+			if configGroup.Title != "" {
+				groupSchema["title"] = configGroup.Title
+			}
+			if configGroup.Description != "" {
+				groupSchema["description"] = configGroup.Description
+			}
+		}
+
+		for _, configItem := range configGroup.Items {
+			keys := valuesOpts.configItemKeys(configGroup, configItem)
+			value, _ := getValuesPath(values, keys)
+			itemSchema := map[string]interface{}{
+				"type": schemaTypeForValue(value),
+			}
+			if valuesOpts.Derived[configItem.Name] && configItem.Type == "bool" {
+				itemSchema["type"] = []string{"boolean", "null"}
+			}
+			if configItem.Title != "" {
+				itemSchema["title"] = configItem.Title
+			}
+			if configItem.HelpText != "" {
+				itemSchema["description"] = configItem.HelpText
+			}
+
+			parentProperties := properties
+			if len(keys) > 1 {
+				parentProperties = schemaObjectAt(properties, keys[:len(keys)-1])["properties"].(map[string]interface{})
+			}
+			parentProperties[keys[len(keys)-1]] = itemSchema
+		}
+	}
+
+	schema := map[string]interface{}{
+		"$schema":    "http://json-schema.org/schema#",
+		"type":       "object",
+		"properties": properties,
+	}
+
+	rendered, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal values schema")
+	}
+
+	fileName := filepath.Join(workspace, "values.schema.json")
+
+	if err := os.WriteFile(fileName, rendered, 0644); err != nil {
+		return errors.Wrap(err, "failed to write values.schema.json")
+	}
+
+	return nil
+}
+
+// schemaObjectAt returns the object schema at the path of keys, creating the objects on the way
+func schemaObjectAt(properties map[string]interface{}, keys []string) map[string]interface{} {
+	var schema map[string]interface{}
+	for _, key := range keys {
+		next, ok := properties[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{
+				"type":       "object",
+				"properties": map[string]interface{}{},
+			}
+			properties[key] = next
+		}
+		nextProperties, ok := next["properties"].(map[string]interface{})
+		if !ok {
+			nextProperties = map[string]interface{}{}
+			next["properties"] = nextProperties
+		}
+		schema = next
+		properties = nextProperties
+	}
+
+	return schema
+}
+
+// getValuesFromConfig returns the helm values for a kots config, keeping the kots defaults.
+// items are put in the values by the layout and mapping in valuesOpts
+func getValuesFromConfig(kotsConfig *kotsv1beta1.Config, valuesOpts ValuesOpts) map[string]interface{} {
+	values := map[string]interface{}{}
+
+	// always present
+	values["nameOverride"] = ""
+	values["fullnameOverride"] = ""
+	values["isKurl"] = false
+	values["isAirgap"] = false
+	values["distribution"] = ""
+	values["kotsVersion"] = ""
+	values["proxy"] = map[string]interface{}{
+		"httpProxy":  "",
+		"httpsProxy": "",
+		"noProxy":    "",
+	}
+
+	filenames := map[string]interface{}{}
+	passwords := map[string]interface{}{}
+	for _, configGroup := range kotsConfig.Spec.Groups {
+		if groupKey, ok := valuesOpts.groupKey(configGroup); ok {
+			if _, ok := values[groupKey]; !ok {
+				values[groupKey] = map[string]interface{}{}
+			}
+		}
+
+		for _, configItem := range configGroup.Items {
+			keys := valuesOpts.configItemKeys(configGroup, configItem)
+			if configItem.Type == "file" {
+				filenames[valuesOpts.key(configItem.Name)] = ""
+			}
+			if isPasswordConfigItem(configItem) {
+				passwords[valuesOpts.key(configItem.Name)] = map[string]interface{}{
+					"existingSecret":    "",
+					"existingSecretKey": "",
+				}
+			}
+			setValuesPath(values, keys, configItemValuesDefault(configGroup, configItem, valuesOpts))
+		}
+	}
+
+	if len(filenames) > 0 {
+		values[configFilenamesKey] = filenames
+	}
+	if len(passwords) > 0 {
+		values[passwordsKey] = passwords
+	}
+
+	return values
+}
+
+// configItemValuesDefault returns the value of a config item in values.yaml
+func configItemValuesDefault(configGroup kotsv1beta1.ConfigGroup, configItem kotsv1beta1.ConfigItem, valuesOpts ValuesOpts) interface{} {
+	if isRandomConfigItem(configGroup, configItem) {
+		// generated by the chart unless it's set
+		return ""
+	}
+	if valuesOpts.Derived[configItem.Name] {
+		// derived by the chart unless it's set. bools are null so false is a value
+		if configItem.Type == "bool" {
+			return nil
+		}
+		return ""
+	}
+	if isPasswordConfigItem(configItem) {
+		// the default is kept in the templates that read the password, so it isn't in
+		// plain text in values.yaml
+		return ""
+	}
+
+	return defaultValueForConfigItem(configItem)
+}
+
+// configItemValuesReference returns the template expression for a config item in .Values,
+// which falls back to the item's value in values.yaml when the user's values don't have it
+func configItemValuesReference(configGroup kotsv1beta1.ConfigGroup, configItem kotsv1beta1.ConfigItem, valuesOpts ValuesOpts) string {
+	fallback := configItemValuesDefault(configGroup, configItem, valuesOpts)
+	if s, ok := fallback.(string); ok && (strings.Contains(s, "{{") || strings.Contains(s, "}}")) {
+		// a default with template delimiters in it would be read as a template, or as an
+		// unconverted kots function
+		fallback = ""
+	}
+
+	reference := valuesReference(valuesOpts.configItemKeys(configGroup, configItem), fallback)
+	if isPasswordConfigItem(configItem) && !isRandomConfigItem(configGroup, configItem) {
+		if passwordDefault := configItem.Default.String(); passwordDefault != "" && !strings.Contains(passwordDefault, "{{") && !strings.Contains(passwordDefault, "}}") {
+			reference = fmt.Sprintf("%s | default %q", reference, passwordDefault)
+		}
+	}
+
+	return reference
+}
+
+// defaultValueForConfigItem returns the typed helm value for the default of a config item.
+// bools are true or false, everything else is the string that ConfigOption returns
+func defaultValueForConfigItem(configItem kotsv1beta1.ConfigItem) interface{} {
+	value, err := helmValueForConfigValue(configItem, configItem.Default.String())
+	if err != nil {
+		// an empty bool default is false
+		return false
+	}
+
+	return value
+}
+
+func schemaTypeForValue(value interface{}) string {
+	switch v := value.(type) {
+	case bool:
+		return "boolean"
+	case int, int64, float64:
+		return "number"
+	case interface{ MarshalYAML() (interface{}, error) }:
+		marshalled, err := v.MarshalYAML()
+		if err != nil {
+			return "string"
+		}
+		return schemaTypeForValue(marshalled)
+	}
+
+	return "string"
+}
@@ -0,0 +1,81 @@
+package builder
+
+import (
+	"testing"
+
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/replicatedhq/kots/kotskinds/multitype"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_getValuesFromConfig(t *testing.T) {
+	kotsConfig := &kotsv1beta1.Config{
+		Spec: kotsv1beta1.ConfigSpec{
+			Groups: []kotsv1beta1.ConfigGroup{
+				{
+					Name: "group1",
+					Items: []kotsv1beta1.ConfigItem{
+						{Name: "enabled", Type: "bool", Default: multitype.FromString("1")},
+						{Name: "disabled", Type: "bool", Default: multitype.FromBool(false)},
+						{Name: "unset", Type: "bool"},
+						{Name: "port", Type: "text", Default: multitype.FromString("5432")},
+						{Name: "flag", Type: "text", Default: multitype.FromBool(true)},
+					},
+				},
+			},
+		},
+	}
+
+	values := getValuesFromConfig(kotsConfig, ValuesOpts{})
+	assert.Equal(t, map[string]interface{}{
+		"enabled":  true,
+		"disabled": false,
+		"unset":    false,
+		"port":     "5432",
+		"flag":     "1",
+	}, values["group1"])
+}
+
+func Test_getValuesFromConfigLayout(t *testing.T) {
+	kotsConfig := &kotsv1beta1.Config{
+		Spec: kotsv1beta1.ConfigSpec{
+			Groups: []kotsv1beta1.ConfigGroup{
+				{
+					Name: "database",
+					Items: []kotsv1beta1.ConfigItem{
+						{Name: "db_host", Type: "text", Default: multitype.FromString("postgres")},
+						{Name: "postgres_password", Type: "password", Default: multitype.FromString("secret")},
+					},
+				},
+			},
+		},
+	}
+
+	values := getValuesFromConfig(kotsConfig, ValuesOpts{
+		Layout: ValuesLayoutFlat,
+		Mapping: map[string][]string{
+			"postgres_password": {"postgresql", "auth", "password"},
+		},
+	})
+	assert.Equal(t, "postgres", values["db_host"])
+	assert.Equal(t, map[string]interface{}{
+		"auth": map[string]interface{}{
+			// a password's default isn't written to values.yaml
+			"password": "",
+		},
+	}, values["postgresql"])
+	assert.NotContains(t, values, "database")
+
+	values = getValuesFromConfig(kotsConfig, ValuesOpts{
+		Layout: ValuesLayoutGrouped,
+		Locked: map[string][]string{
+			"db_host": {"other", "db_host"},
+		},
+	})
+	assert.Equal(t, map[string]interface{}{
+		"postgres_password": "",
+	}, values["database"])
+	assert.Equal(t, map[string]interface{}{
+		"db_host": "postgres",
+	}, values["other"])
+}
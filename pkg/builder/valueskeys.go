@@ -0,0 +1,307 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+)
+
+// ValuesNaming controls how kots group and item names become keys in the chart's values
+type ValuesNaming string
+
+const (
+	// ValuesNamingIndex keeps the kots names as values keys. names that can't be used in a
+	// template path, such as ones with dashes, are read with index
+	ValuesNamingIndex ValuesNaming = "index"
+	// ValuesNamingCamelCase converts the kots names to camelCase keys, so my-group.db_host
+	// is myGroup.dbHost
+	ValuesNamingCamelCase ValuesNaming = "camelCase"
+)
+
+// ValuesLayout controls where config items are put in the chart's values
+type ValuesLayout string
+
+const (
+	// ValuesLayoutGrouped puts each item under its group, the same as the kots config
+	ValuesLayoutGrouped ValuesLayout = "grouped"
+	// ValuesLayoutFlat puts every item at the top level of the values
+	ValuesLayoutFlat ValuesLayout = "flat"
+)
+
+// ValuesOpts control the keys of the config items in the chart's values
+type ValuesOpts struct {
+	Naming ValuesNaming `json:"naming"`
+	Layout ValuesLayout `json:"layout"`
+	// Mapping pins config items, by name, to a path of values keys. it comes from the
+	// --values-map file and wins over everything else
+	Mapping map[string][]string `json:"mapping,omitempty"`
+	// Locked are the paths of the config items in the values-map.lock, so items keep their
+	// path when they move between groups
+	Locked map[string][]string `json:"locked,omitempty"`
+	// Derived are the config items, by name, whose template is rendered by the chart when
+	// they aren't set in the values
+	Derived map[string]bool `json:"derived,omitempty"`
+	// Conditional are the config items, by name, that are hidden by their when or their
+	// group's when. the chart renders them as empty when they're hidden
+	Conditional map[string]bool `json:"conditional,omitempty"`
+	// Validations are the regex validations of the config items, by name, that the chart
+	// checks before it renders an item's value
+	Validations map[string]ConfigItemValidation `json:"validations,omitempty"`
+}
+
+// isTemplated is true when a config item is rendered by a named template that renders
+// bools as "1" and "0", instead of being read from .Values
+func (opts ValuesOpts) isTemplated(item kotsv1beta1.ConfigItem) bool {
+	return opts.Derived[item.Name] || opts.Conditional[item.Name]
+}
+
+// reservedValuesKeys are the top level values keys that config groups can't use. global is
+// shared with subcharts by helm, the rest are created by kots2helm
+var reservedValuesKeys = []string{
+	"global",
+	"nameOverride",
+	"fullnameOverride",
+	"isKurl",
+	"isAirgap",
+	"distribution",
+	"kotsVersion",
+	"proxy",
+	"kurl",
+	"additionalNamespaces",
+	configFilenamesKey,
+	passwordsKey,
+}
+
+var (
+	templateIdentifierRegex = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	valuesKeySeparatorRegex = regexp.MustCompile(`[-_.\s]+`)
+)
+
+// ParseValuesNaming parses the --values-naming flag
+func ParseValuesNaming(s string) (ValuesNaming, error) {
+	switch ValuesNaming(s) {
+	case "":
+		return ValuesNamingIndex, nil
+	case ValuesNamingIndex, ValuesNamingCamelCase:
+		return ValuesNaming(s), nil
+	}
+
+	return "", errors.Errorf("unknown values naming %q, must be one of index or camelCase", s)
+}
+
+// ParseValuesLayout parses the --values-layout flag
+func ParseValuesLayout(s string) (ValuesLayout, error) {
+	switch ValuesLayout(s) {
+	case "":
+		return ValuesLayoutGrouped, nil
+	case ValuesLayoutGrouped, ValuesLayoutFlat:
+		return ValuesLayout(s), nil
+	}
+
+	return "", errors.Errorf("unknown values layout %q, must be one of grouped or flat", s)
+}
+
+// key returns the values key for a kots group or item name
+func (opts ValuesOpts) key(name string) string {
+	if opts.Naming != ValuesNamingCamelCase {
+		return name
+	}
+
+	parts := valuesKeySeparatorRegex.Split(name, -1)
+	key := parts[0]
+	for _, part := range parts[1:] {
+		if part == "" {
+			continue
+		}
+		runes := []rune(part)
+		key += string(unicode.ToUpper(runes[0])) + string(runes[1:])
+	}
+
+	return key
+}
+
+// configItemKeys returns the path of keys to a config item in the values
+func (opts ValuesOpts) configItemKeys(group kotsv1beta1.ConfigGroup, item kotsv1beta1.ConfigItem) []string {
+	if keys, ok := opts.Mapping[item.Name]; ok {
+		return keys
+	}
+	if keys, ok := opts.Locked[item.Name]; ok {
+		return keys
+	}
+	if opts.Layout == ValuesLayoutFlat {
+		return []string{opts.key(item.Name)}
+	}
+
+	return []string{opts.key(group.Name), opts.key(item.Name)}
+}
+
+// groupKey returns the values key of a config group, which is false with the flat layout
+// because groups don't have one
+func (opts ValuesOpts) groupKey(group kotsv1beta1.ConfigGroup) (string, bool) {
+	if opts.Layout == ValuesLayoutFlat {
+		return "", false
+	}
+
+	return opts.key(group.Name), true
+}
+
+// isGroupedItem is true when a config item is under its group's key
+func (opts ValuesOpts) isGroupedItem(item kotsv1beta1.ConfigItem) bool {
+	if _, ok := opts.Mapping[item.Name]; ok {
+		return false
+	}
+	if _, ok := opts.Locked[item.Name]; ok {
+		return false
+	}
+
+	return opts.Layout != ValuesLayoutFlat
+}
+
+// setValuesPath sets the value at the path of keys, creating the maps on the way
+func setValuesPath(values map[string]interface{}, keys []string, value interface{}) {
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := values[key].(map[string]interface{})
+		if !ok {
+			next = map[string]interface{}{}
+			values[key] = next
+		}
+		values = next
+	}
+	values[keys[len(keys)-1]] = value
+}
+
+// getValuesPath returns the value at the path of keys
+func getValuesPath(values map[string]interface{}, keys []string) (interface{}, bool) {
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := values[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		values = next
+	}
+
+	value, ok := values[keys[len(keys)-1]]
+	return value, ok
+}
+
+// valuesReference returns the template expression for the values at the path of keys. dig
+// falls back to the given default when the user's values don't have the path, instead of
+// failing on a nil pointer when a map on the path is missing
+func valuesReference(keys []string, fallback interface{}) string {
+	return fmt.Sprintf("dig %s %s .Values.AsMap", quoteKeys(keys), valuesLiteral(fallback))
+}
+
+// valuesLiteral returns a template literal for a value in values.yaml
+func valuesLiteral(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case string:
+		return fmt.Sprintf("%q", v)
+	}
+
+	return `""`
+}
+
+func quoteKeys(keys []string) string {
+	quoted := []string{}
+	for _, key := range keys {
+		quoted = append(quoted, fmt.Sprintf("%q", key))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// validateValuesKeys returns an error naming every config group and item whose values key
+// is reserved, or is the same as another group or item's key. an item can't be at a path
+// that holds a group or other items either
+func validateValuesKeys(kotsConfig *kotsv1beta1.Config, opts ValuesOpts) error {
+	problems := []string{}
+
+	reserved := map[string]bool{}
+	for _, key := range reservedValuesKeys {
+		reserved[key] = true
+	}
+
+	items := map[string]bool{}
+	for _, group := range kotsConfig.Spec.Groups {
+		for _, item := range group.Items {
+			items[item.Name] = true
+		}
+	}
+	for name := range opts.Mapping {
+		if !items[name] {
+			problems = append(problems, fmt.Sprintf("the values map has %q, which isn't a config item", name))
+		}
+	}
+
+	// the maps that groups and items are in, by path, named for the problems
+	parents := map[string]string{}
+	addParents := func(keys []string, owner string) {
+		for i := 1; i < len(keys); i++ {
+			path := strings.Join(keys[:i], ".")
+			if _, ok := parents[path]; !ok {
+				parents[path] = owner
+			}
+		}
+	}
+
+	groupNames := map[string]string{}
+	for _, group := range kotsConfig.Spec.Groups {
+		groupKey, ok := opts.groupKey(group)
+		if !ok {
+			continue
+		}
+		if reserved[groupKey] {
+			problems = append(problems, fmt.Sprintf("group %q uses the key %q, which is reserved", group.Name, groupKey))
+		} else if other, ok := groupNames[groupKey]; ok && other != group.Name {
+			problems = append(problems, fmt.Sprintf("groups %q and %q both use the key %q", other, group.Name, groupKey))
+		}
+		groupNames[groupKey] = group.Name
+		addParents([]string{groupKey, ""}, fmt.Sprintf("group %q", group.Name))
+	}
+
+	type itemPath struct {
+		group string
+		item  string
+	}
+	itemPaths := map[string]itemPath{}
+	for _, group := range kotsConfig.Spec.Groups {
+		for _, item := range group.Items {
+			keys := opts.configItemKeys(group, item)
+			path := strings.Join(keys, ".")
+			if !opts.isGroupedItem(item) && reserved[keys[0]] {
+				problems = append(problems, fmt.Sprintf("item %q uses the key %q, which is reserved", item.Name, keys[0]))
+			}
+
+			if other, ok := itemPaths[path]; ok && other.item != item.Name {
+				if other.group == group.Name && opts.isGroupedItem(item) {
+					problems = append(problems, fmt.Sprintf("items %q and %q in group %q both use the key %q", other.item, item.Name, group.Name, keys[len(keys)-1]))
+				} else {
+					problems = append(problems, fmt.Sprintf("items %q and %q both use the values path %q", other.item, item.Name, path))
+				}
+			}
+			itemPaths[path] = itemPath{group: group.Name, item: item.Name}
+			addParents(keys, fmt.Sprintf("item %q", item.Name))
+		}
+	}
+	for path, item := range itemPaths {
+		if owner, ok := parents[path]; ok {
+			problems = append(problems, fmt.Sprintf("item %q uses the values path %q, which holds %s", item.item, path, owner))
+		}
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+
+	sort.Strings(problems)
+	return errors.Errorf("config names can't be used as values keys:\n  %s", strings.Join(problems, "\n  "))
+}
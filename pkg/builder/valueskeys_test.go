@@ -0,0 +1,166 @@
+package builder
+
+import (
+	"testing"
+
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_valuesReference(t *testing.T) {
+	tests := []struct {
+		name   string
+		naming ValuesNaming
+		layout ValuesLayout
+		group  string
+		item   string
+		expect string
+	}{
+		{
+			name:   "index, valid identifiers",
+			naming: ValuesNamingIndex,
+			group:  "database",
+			item:   "db_host",
+			expect: `dig "database" "db_host" "" .Values.AsMap`,
+		},
+		{
+			name:   "index, dashes",
+			naming: ValuesNamingIndex,
+			group:  "my-group",
+			item:   "db_host",
+			expect: `dig "my-group" "db_host" "" .Values.AsMap`,
+		},
+		{
+			name:   "camelCase",
+			naming: ValuesNamingCamelCase,
+			group:  "my-group",
+			item:   "db_host.name",
+			expect: `dig "myGroup" "dbHostName" "" .Values.AsMap`,
+		},
+		{
+			name:   "camelCase, leading digit",
+			naming: ValuesNamingCamelCase,
+			group:  "settings",
+			item:   "2fa-enabled",
+			expect: `dig "settings" "2faEnabled" "" .Values.AsMap`,
+		},
+		{
+			name:   "flat",
+			naming: ValuesNamingIndex,
+			layout: ValuesLayoutFlat,
+			group:  "database",
+			item:   "db_host",
+			expect: `dig "db_host" "" .Values.AsMap`,
+		},
+		{
+			name:   "mapped",
+			naming: ValuesNamingCamelCase,
+			layout: ValuesLayoutFlat,
+			group:  "database",
+			item:   "postgres_password",
+			expect: `dig "postgresql" "auth" "password" "" .Values.AsMap`,
+		},
+		{
+			name:   "locked",
+			naming: ValuesNamingIndex,
+			group:  "database",
+			item:   "db-port",
+			expect: `dig "old-group" "db-port" "" .Values.AsMap`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opts := ValuesOpts{
+				Naming: tt.naming,
+				Layout: tt.layout,
+				Mapping: map[string][]string{
+					"postgres_password": {"postgresql", "auth", "password"},
+				},
+				Locked: map[string][]string{
+					"db-port":           {"old-group", "db-port"},
+					"postgres_password": {"database", "postgres_password"},
+				},
+			}
+			reference := configItemValuesReference(kotsv1beta1.ConfigGroup{Name: tt.group}, kotsv1beta1.ConfigItem{Name: tt.item, Type: "text"}, opts)
+			assert.Equal(t, tt.expect, reference)
+		})
+	}
+}
+
+func Test_validateValuesKeys(t *testing.T) {
+	kotsConfig := &kotsv1beta1.Config{
+		Spec: kotsv1beta1.ConfigSpec{
+			Groups: []kotsv1beta1.ConfigGroup{
+				{
+					Name: "global",
+				},
+				{
+					Name: "my-group",
+					Items: []kotsv1beta1.ConfigItem{
+						{Name: "db-host"},
+						{Name: "db_host"},
+					},
+				},
+				{
+					Name: "my_group",
+				},
+			},
+		},
+	}
+
+	err := validateValuesKeys(kotsConfig, ValuesOpts{Naming: ValuesNamingIndex})
+	assert.EqualError(t, err, `config names can't be used as values keys:
+  group "global" uses the key "global", which is reserved`)
+
+	err = validateValuesKeys(kotsConfig, ValuesOpts{Naming: ValuesNamingCamelCase})
+	assert.EqualError(t, err, `config names can't be used as values keys:
+  group "global" uses the key "global", which is reserved
+  groups "my-group" and "my_group" both use the key "myGroup"
+  items "db-host" and "db_host" in group "my-group" both use the key "dbHost"`)
+}
+
+func Test_validateValuesKeysLayout(t *testing.T) {
+	kotsConfig := &kotsv1beta1.Config{
+		Spec: kotsv1beta1.ConfigSpec{
+			Groups: []kotsv1beta1.ConfigGroup{
+				{
+					Name: "database",
+					Items: []kotsv1beta1.ConfigItem{
+						{Name: "global"},
+						{Name: "db_host"},
+						{Name: "postgres_password"},
+					},
+				},
+				{
+					Name: "settings",
+					Items: []kotsv1beta1.ConfigItem{
+						{Name: "db-host"},
+					},
+				},
+			},
+		},
+	}
+
+	err := validateValuesKeys(kotsConfig, ValuesOpts{
+		Naming: ValuesNamingCamelCase,
+		Layout: ValuesLayoutFlat,
+		Mapping: map[string][]string{
+			"postgres_password": {"dbHost", "password"},
+			"missing":           {"missing"},
+		},
+	})
+	assert.EqualError(t, err, `config names can't be used as values keys:
+  item "db-host" uses the values path "dbHost", which holds item "postgres_password"
+  item "global" uses the key "global", which is reserved
+  items "db_host" and "db-host" both use the values path "dbHost"
+  the values map has "missing", which isn't a config item`)
+
+	err = validateValuesKeys(kotsConfig, ValuesOpts{
+		Layout: ValuesLayoutGrouped,
+		Mapping: map[string][]string{
+			"postgres_password": {"settings"},
+		},
+	})
+	assert.EqualError(t, err, `config names can't be used as values keys:
+  item "postgres_password" uses the values path "settings", which holds group "settings"`)
+}
@@ -0,0 +1,180 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/replicatedhq/kots2helm/pkg/logger"
+	"gopkg.in/yaml.v3"
+)
+
+const valuesLockHeader = `# generated by kots2helm. commit this file so config items keep their values paths
+# when they're renamed or move between groups. delete it to use the --values-layout again
+`
+
+// valuesLock is the values-map.lock file, the values path of every config item the last
+// time the chart was built
+type valuesLock struct {
+	Layout ValuesLayout          `yaml:"layout"`
+	Naming ValuesNaming          `yaml:"naming"`
+	Items  map[string]valuesPath `yaml:"items"`
+}
+
+// valuesPath is a path of values keys. it's written as a list, because keys can have dots
+type valuesPath []string
+
+func (p valuesPath) MarshalYAML() (interface{}, error) {
+	node := &yaml.Node{
+		Kind:  yaml.SequenceNode,
+		Style: yaml.FlowStyle,
+	}
+	for _, key := range p {
+		node.Content = append(node.Content, &yaml.Node{
+			Kind:  yaml.ScalarNode,
+			Value: key,
+		})
+	}
+
+	return node, nil
+}
+
+// DefaultValuesLockFile returns the values-map.lock for inputDir, which is next to it so
+// that it's committed with the manifests and isn't copied into the chart
+func DefaultValuesLockFile(inputDir string) string {
+	return filepath.Join(filepath.Dir(filepath.Clean(inputDir)), "values-map.lock")
+}
+
+// GetValuesOpts parses the values flags and reads the mapping and lock files. either
+// file can be empty, and the lock file doesn't have to exist yet
+func GetValuesOpts(naming string, layout string, mappingFile string, lockFile string) (ValuesOpts, error) {
+	valuesNaming, err := ParseValuesNaming(naming)
+	if err != nil {
+		return ValuesOpts{}, err
+	}
+	valuesLayout, err := ParseValuesLayout(layout)
+	if err != nil {
+		return ValuesOpts{}, err
+	}
+
+	opts := ValuesOpts{
+		Naming: valuesNaming,
+		Layout: valuesLayout,
+	}
+
+	if mappingFile != "" {
+		mapping, err := readValuesMapping(mappingFile)
+		if err != nil {
+			return ValuesOpts{}, errors.Wrapf(err, "failed to read values map %s", mappingFile)
+		}
+		opts.Mapping = mapping
+	}
+
+	if lockFile != "" {
+		lock, err := readValuesLock(lockFile)
+		if err != nil {
+			return ValuesOpts{}, errors.Wrapf(err, "failed to read values lock %s", lockFile)
+		}
+		if lock.Layout != "" && (lock.Layout != opts.Layout || lock.Naming != opts.Naming) {
+			logger.Warnf("%s was written with --values-layout=%s --values-naming=%s, they're used until it's deleted", lockFile, lock.Layout, lock.Naming)
+		}
+		// new items are added with the layout and naming of the lock, so the lock stays
+		// true to its header
+		if lock.Layout != "" {
+			opts.Layout = lock.Layout
+		}
+		if lock.Naming != "" {
+			opts.Naming = lock.Naming
+		}
+		for name, keys := range lock.Items {
+			if opts.Locked == nil {
+				opts.Locked = map[string][]string{}
+			}
+			opts.Locked[name] = keys
+		}
+	}
+
+	return opts, nil
+}
+
+// readValuesMapping reads a values map file, which has the values path of config items by
+// name, with dots between the keys:
+//
+//	postgres_password: postgresql.auth.password
+func readValuesMapping(filename string) (map[string][]string, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := map[string]string{}
+	if err := yaml.Unmarshal(content, &paths); err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal")
+	}
+
+	mapping := map[string][]string{}
+	for name, path := range paths {
+		keys := strings.Split(path, ".")
+		for _, key := range keys {
+			if key == "" {
+				return nil, errors.Errorf("%s has an invalid values path %q", name, path)
+			}
+		}
+		mapping[name] = keys
+	}
+
+	return mapping, nil
+}
+
+// readValuesLock reads a values-map.lock. it's empty when the file doesn't exist
+func readValuesLock(filename string) (valuesLock, error) {
+	lock := valuesLock{}
+
+	content, err := os.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return lock, err
+	}
+
+	if err := yaml.Unmarshal(content, &lock); err != nil {
+		return lock, errors.Wrap(err, "failed to unmarshal")
+	}
+
+	for name, keys := range lock.Items {
+		if len(keys) == 0 {
+			return lock, errors.Errorf("%s has an empty values path", name)
+		}
+	}
+
+	return lock, nil
+}
+
+// writeValuesLock writes the values path of every config item to the lock file. items that
+// are no longer in the config are dropped from it
+func writeValuesLock(filename string, kotsConfig *kotsv1beta1.Config, valuesOpts ValuesOpts) error {
+	lock := valuesLock{
+		Layout: valuesOpts.Layout,
+		Naming: valuesOpts.Naming,
+		Items:  map[string]valuesPath{},
+	}
+	for _, group := range kotsConfig.Spec.Groups {
+		for _, item := range group.Items {
+			lock.Items[item.Name] = valuesOpts.configItemKeys(group, item)
+		}
+	}
+
+	rendered, err := yaml.Marshal(lock)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal values lock")
+	}
+
+	if err := os.WriteFile(filename, append([]byte(valuesLockHeader), rendered...), 0644); err != nil {
+		return errors.Wrap(err, "failed to write values lock")
+	}
+
+	return nil
+}
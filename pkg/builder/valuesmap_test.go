@@ -0,0 +1,86 @@
+package builder
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GetValuesOpts(t *testing.T) {
+	dir := t.TempDir()
+	mappingFile := filepath.Join(dir, "values-map.yaml")
+	lockFile := filepath.Join(dir, "values-map.lock")
+
+	require.NoError(t, os.WriteFile(mappingFile, []byte("postgres_password: postgresql.auth.password\n"), 0644))
+
+	// the lock doesn't exist before the first build
+	opts, err := GetValuesOpts("", "", mappingFile, lockFile)
+	require.NoError(t, err)
+	assert.Equal(t, ValuesNamingIndex, opts.Naming)
+	assert.Equal(t, ValuesLayoutGrouped, opts.Layout)
+	assert.Equal(t, map[string][]string{
+		"postgres_password": {"postgresql", "auth", "password"},
+	}, opts.Mapping)
+	assert.Nil(t, opts.Locked)
+
+	kotsConfig := &kotsv1beta1.Config{
+		Spec: kotsv1beta1.ConfigSpec{
+			Groups: []kotsv1beta1.ConfigGroup{
+				{
+					Name: "database",
+					Items: []kotsv1beta1.ConfigItem{
+						{Name: "db.host"},
+						{Name: "postgres_password"},
+					},
+				},
+			},
+		},
+	}
+	require.NoError(t, writeValuesLock(lockFile, kotsConfig, opts))
+
+	content, err := os.ReadFile(lockFile)
+	require.NoError(t, err)
+	assert.Equal(t, valuesLockHeader+`layout: grouped
+naming: index
+items:
+    db.host: [database, db.host]
+    postgres_password: [postgresql, auth, password]
+`, string(content))
+
+	// moving an item to another group keeps its path
+	kotsConfig.Spec.Groups[0].Items = kotsConfig.Spec.Groups[0].Items[1:]
+	kotsConfig.Spec.Groups = append(kotsConfig.Spec.Groups, kotsv1beta1.ConfigGroup{
+		Name: "settings",
+		Items: []kotsv1beta1.ConfigItem{
+			{Name: "db.host"},
+		},
+	})
+
+	opts, err = GetValuesOpts("", "", "", lockFile)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"database", "db.host"}, opts.configItemKeys(kotsConfig.Spec.Groups[1], kotsConfig.Spec.Groups[1].Items[0]))
+	assert.Equal(t, []string{"postgresql", "auth", "password"}, opts.configItemKeys(kotsConfig.Spec.Groups[0], kotsConfig.Spec.Groups[0].Items[0]))
+
+	// the layout and naming of the lock win over the flags, so new items match the header
+	opts, err = GetValuesOpts(string(ValuesNamingCamelCase), string(ValuesLayoutFlat), "", lockFile)
+	require.NoError(t, err)
+	assert.Equal(t, ValuesNamingIndex, opts.Naming)
+	assert.Equal(t, ValuesLayoutGrouped, opts.Layout)
+	require.NoError(t, writeValuesLock(lockFile, kotsConfig, opts))
+	content, err = os.ReadFile(lockFile)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "layout: grouped\nnaming: index\n")
+
+	assert.Equal(t, filepath.Join("apps", "values-map.lock"), DefaultValuesLockFile("apps/manifests/"))
+
+	_, err = GetValuesOpts("", "nested", "", "")
+	assert.EqualError(t, err, `unknown values layout "nested", must be one of grouped or flat`)
+
+	require.NoError(t, os.WriteFile(mappingFile, []byte("postgres_password: postgresql..password\n"), 0644))
+	_, err = GetValuesOpts("", "", mappingFile, "")
+	assert.Error(t, err)
+}
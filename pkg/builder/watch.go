@@ -0,0 +1,372 @@
+package builder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	"github.com/plus3it/gorecurcopy"
+	"github.com/replicatedhq/kots2helm/pkg/logger"
+)
+
+// watchDebounce is how long to wait for more changes before converting, editors
+// often write a file several times when saving
+const watchDebounce = 200 * time.Millisecond
+
+// chartWatcher keeps a chart directory in sync with the kots manifests in an input dir
+type chartWatcher struct {
+	inputDir  string
+	outputDir string
+	name      string
+	version   string
+	opts      BuildOpts
+
+	configSpec     []byte
+	valuesInputs   []byte
+	conversionOpts conversionOpts
+	remaining      map[string]int
+}
+
+// Watch will convert the input dir to an unpacked helm chart in output dir, and then
+// convert each manifest again as it changes until ctx is done. when the kots config
+// changes, the values, schema and every template are regenerated. when the kots
+// application or kurl installer changes, the values and schema are regenerated
+func Watch(ctx context.Context, inputDir string, outputDir string, name string, version string, opts BuildOpts) error {
+	if _, err := getUnconvertedPolicy(opts); err != nil {
+		return err
+	}
+
+	absInputDir, err := filepath.Abs(inputDir)
+	if err != nil {
+		return errors.Wrap(err, "failed to get input dir")
+	}
+	absOutputDir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return errors.Wrap(err, "failed to get output dir")
+	}
+	if absOutputDir == absInputDir || strings.HasPrefix(absOutputDir, absInputDir+string(filepath.Separator)) {
+		return errors.New("output dir cannot be inside the input dir")
+	}
+
+	w := &chartWatcher{
+		inputDir:  absInputDir,
+		outputDir: absOutputDir,
+		name:      name,
+		version:   version,
+		opts:      opts,
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "failed to create watcher")
+	}
+	defer watcher.Close()
+
+	if err := watchDirectory(watcher, w.inputDir); err != nil {
+		return err
+	}
+
+	index, err := indexWorkspace(w.inputDir)
+	if err != nil {
+		return err
+	}
+	if err := w.convertAll(index); err != nil {
+		return err
+	}
+	w.report()
+
+	changed := map[string]bool{}
+	timer := time.NewTimer(watchDebounce)
+	timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if isIgnoredWatchPath(event.Name) {
+				continue
+			}
+
+			if event.Op&fsnotify.Create != 0 {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					if err := watchDirectory(watcher, event.Name); err != nil {
+						logger.Error(err)
+					}
+				}
+			}
+
+			changed[event.Name] = true
+			timer.Reset(watchDebounce)
+
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			logger.Error(errors.Wrap(err, "watch error"))
+
+		case <-timer.C:
+			paths := changed
+			changed = map[string]bool{}
+
+			if err := w.convertChanged(paths); err != nil {
+				// keep watching, the next change may fix it
+				logger.Error(err)
+				continue
+			}
+			w.report()
+		}
+	}
+}
+
+func watchDirectory(watcher *fsnotify.Watcher, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if err := watcher.Add(path); err != nil {
+			return errors.Wrapf(err, "failed to watch %s", path)
+		}
+		return nil
+	})
+}
+
+// isIgnoredWatchPath is true for editor swap and backup files
+func isIgnoredWatchPath(path string) bool {
+	base := filepath.Base(path)
+	return strings.HasPrefix(base, ".") || strings.HasSuffix(base, "~")
+}
+
+// convertAll copies every manifest in the input dir to the chart and converts it. index
+// is the index of the input dir
+func (w *chartWatcher) convertAll(index *workspaceIndex) error {
+	templatesDir := filepath.Join(w.outputDir, "templates")
+	if err := os.RemoveAll(templatesDir); err != nil {
+		return errors.Wrap(err, "failed to remove templates")
+	}
+	if err := os.RemoveAll(filepath.Join(w.outputDir, "crds")); err != nil {
+		return errors.Wrap(err, "failed to remove crds")
+	}
+	if err := os.MkdirAll(templatesDir, 0755); err != nil {
+		return errors.Wrap(err, "failed to create templates")
+	}
+
+	if err := gorecurcopy.CopyDirectory(w.inputDir, templatesDir); err != nil {
+		return errors.Wrap(err, "failed to copy input dir")
+	}
+
+	configSpec, err := getConfigSpec(index)
+	if err != nil {
+		return err
+	}
+	conversionOpts, err := getConversionOpts(index, w.name, w.opts)
+	if err != nil {
+		return err
+	}
+
+	remaining, err := convertWorkspace(w.outputDir, w.name, w.version, index, conversionOpts, w.opts)
+	if err != nil {
+		return err
+	}
+
+	w.configSpec = configSpec
+	w.valuesInputs = getValuesInputs(index)
+	w.conversionOpts = conversionOpts
+	w.remaining = remaining
+
+	return nil
+}
+
+// convertChanged converts each changed file again. if the config spec
+// has changed, everything is converted. the generated files are created again
+// from the changed files
+func (w *chartWatcher) convertChanged(paths map[string]bool) error {
+	index, err := indexWorkspace(w.inputDir)
+	if err != nil {
+		return err
+	}
+
+	configSpec, err := getConfigSpec(index)
+	if err != nil {
+		return err
+	}
+	if string(configSpec) != string(w.configSpec) {
+		fmt.Println("config changed, regenerating values.yaml, values.schema.json and all templates")
+		return w.convertAll(index)
+	}
+
+	kotsConfig, err := getKOTSConfig(index)
+	if err != nil {
+		return errors.Wrap(err, "failed to get config")
+	}
+	conversionOpts, err := getConversionOpts(index, w.name, w.opts)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(conversionOpts.PrefixedNames, w.conversionOpts.PrefixedNames) || !reflect.DeepEqual(conversionOpts.Namespaces, w.conversionOpts.Namespaces) {
+		fmt.Println("resource names or namespaces changed, converting all templates")
+		return w.convertAll(index)
+	}
+
+	for path := range paths {
+		rel, err := filepath.Rel(w.inputDir, path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to get relative path for %s", path)
+		}
+		chartPath := filepath.Join(w.outputDir, "templates", rel)
+		crdPath := filepath.Join(w.outputDir, "crds", rel)
+		reportPath := filepath.Join("templates", rel)
+
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			fmt.Printf("%s was removed\n", rel)
+			if err := os.RemoveAll(chartPath); err != nil {
+				return errors.Wrapf(err, "failed to remove %s", chartPath)
+			}
+			if err := os.RemoveAll(crdPath); err != nil {
+				return errors.Wrapf(err, "failed to remove %s", crdPath)
+			}
+			delete(w.remaining, reportPath)
+			continue
+		} else if err != nil {
+			return errors.Wrapf(err, "failed to stat %s", path)
+		}
+		if info.IsDir() {
+			continue
+		}
+
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read %s", path)
+		}
+
+		isKots, err := isKOTSManifest(content)
+		if err != nil {
+			return err
+		}
+		if isKots || filepath.Ext(path) == ".tgz" {
+			// kots manifests are not part of the chart
+			if err := os.RemoveAll(chartPath); err != nil {
+				return errors.Wrapf(err, "failed to remove %s", chartPath)
+			}
+			if err := os.RemoveAll(crdPath); err != nil {
+				return errors.Wrapf(err, "failed to remove %s", crdPath)
+			}
+			delete(w.remaining, reportPath)
+			continue
+		}
+
+		fmt.Printf("%s changed, converting\n", rel)
+
+		if err := os.MkdirAll(filepath.Dir(chartPath), 0755); err != nil {
+			return errors.Wrap(err, "failed to create templates dir")
+		}
+		if err := ioutil.WriteFile(chartPath, content, info.Mode()); err != nil {
+			return errors.Wrapf(err, "failed to write %s", chartPath)
+		}
+
+		if err := moveCRDsInFile(w.outputDir, chartPath, conversionOpts); err != nil {
+			return err
+		}
+		if _, err := os.Stat(chartPath); os.IsNotExist(err) {
+			// every document was a CRD
+			delete(w.remaining, reportPath)
+			continue
+		}
+
+		remaining, err := convertTemplateFile(w.outputDir, chartPath, kotsConfig, conversionOpts)
+		if err != nil {
+			return err
+		}
+
+		delete(w.remaining, reportPath)
+		if remaining > 0 {
+			w.remaining[reportPath] = remaining
+		}
+	}
+
+	valuesInputs := getValuesInputs(index)
+	if string(valuesInputs) != string(w.valuesInputs) {
+		fmt.Println("kots application or kurl installer changed, regenerating values.yaml and values.schema.json")
+		if err := createValuesFiles(w.outputDir, index, conversionOpts.Values); err != nil {
+			return err
+		}
+		w.valuesInputs = valuesInputs
+	}
+
+	// the certificates used by the changed files may have changed, and the other
+	// generated templates are made from the same index
+	if err := removeGeneratedTemplates(w.outputDir); err != nil {
+		return err
+	}
+	if err := createGeneratedTemplates(w.outputDir, w.name, index, conversionOpts.Values); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// getValuesInputs returns the kots application and kurl installer documents in index,
+// which values.yaml and values.schema.json are made from along with the config
+func getValuesInputs(index *workspaceIndex) []byte {
+	inputs := []byte{}
+	for _, gvk := range []string{"kots.io/v1beta1/Application", "cluster.kurl.sh/v1beta1/Installer", "kurl.sh/v1beta1/Installer"} {
+		for _, doc := range index.documents[gvk] {
+			inputs = append(inputs, doc.Content...)
+		}
+	}
+
+	return inputs
+}
+
+// getConfigSpec returns the serialized kots config spec from index, or nil
+func getConfigSpec(index *workspaceIndex) ([]byte, error) {
+	kotsConfig, err := getKOTSConfig(index)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get config")
+	}
+	if kotsConfig == nil {
+		return nil, nil
+	}
+
+	spec, err := json.Marshal(kotsConfig.Spec)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal config spec")
+	}
+
+	return spec, nil
+}
+
+// report prints the conversion report and validates the chart. unconverted functions with
+// the fail policy and validation failures are printed, but don't stop watching
+func (w *chartWatcher) report() {
+	if len(w.remaining) == 0 {
+		fmt.Println("all kots template functions were converted")
+	}
+	printConversionReport(w.remaining)
+
+	if unconvertedPolicy, _ := getUnconvertedPolicy(w.opts); unconvertedPolicy == UnconvertedPolicyFail && len(w.remaining) > 0 {
+		fmt.Println(errors.Errorf("%d files have template functions that could not be converted", len(w.remaining)))
+	}
+
+	if err := validateHelmChart(w.outputDir, w.inputDir, w.opts.Strict); err != nil {
+		fmt.Println(err)
+	}
+
+	fmt.Printf("chart is at %s, watching %s for changes\n", w.outputDir, w.inputDir)
+}
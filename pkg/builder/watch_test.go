@@ -0,0 +1,93 @@
+package builder
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_chartWatcher_convertChanged(t *testing.T) {
+	req := require.New(t)
+
+	inputDir := t.TempDir()
+	outputDir := t.TempDir()
+
+	config := `apiVersion: kots.io/v1beta1
+kind: Config
+metadata:
+  name: config
+spec:
+  groups:
+    - name: group1
+      items:
+        - name: foo
+          type: text`
+	req.NoError(ioutil.WriteFile(filepath.Join(inputDir, "config.yaml"), []byte(config), 0644))
+	req.NoError(ioutil.WriteFile(filepath.Join(inputDir, "a.yaml"), []byte(`a: repl{{ ConfigOption "foo" }}`), 0644))
+	req.NoError(ioutil.WriteFile(filepath.Join(inputDir, "b.yaml"), []byte(`b: repl{{ Namespace }}`), 0644))
+
+	w := &chartWatcher{
+		inputDir:  inputDir,
+		outputDir: outputDir,
+		name:      "test",
+		version:   "0.0.1",
+		opts:      BuildOpts{Concurrency: 2},
+	}
+	index, err := indexWorkspace(inputDir)
+	req.NoError(err)
+	req.NoError(w.convertAll(index))
+	assert.Empty(t, w.remaining)
+
+	// only the changed file is converted again
+	req.NoError(ioutil.WriteFile(filepath.Join(inputDir, "a.yaml"), []byte(`a: repl{{ LicenseFieldValue "foo" }}`), 0644))
+	req.NoError(os.Remove(filepath.Join(inputDir, "b.yaml")))
+	req.NoError(w.convertChanged(map[string]bool{
+		filepath.Join(inputDir, "a.yaml"): true,
+		filepath.Join(inputDir, "b.yaml"): true,
+	}))
+
+	assert.Equal(t, map[string]int{filepath.Join("templates", "a.yaml"): 1}, w.remaining)
+	assert.NoFileExists(t, filepath.Join(outputDir, "templates", "b.yaml"))
+
+	// a new certificate is added to the tls secret
+	req.NoError(ioutil.WriteFile(filepath.Join(inputDir, "c.yaml"), []byte(`c: repl{{ TLSCert "api" "api.example.com" }}`), 0644))
+	req.NoError(w.convertChanged(map[string]bool{
+		filepath.Join(inputDir, "c.yaml"): true,
+	}))
+
+	tlsSecret, err := ioutil.ReadFile(filepath.Join(outputDir, "templates", tlsSecretTemplate))
+	req.NoError(err)
+	assert.Contains(t, string(tlsSecret), `"api.example.com"`)
+	assert.FileExists(t, filepath.Join(outputDir, "templates", "_helpers.tpl"))
+
+	// a kurl installer change regenerates the values
+	installer := `apiVersion: cluster.kurl.sh/v1beta1
+kind: Installer
+metadata:
+  name: app
+spec:
+  kubernetes:
+    serviceCIDR: 10.96.0.0/22`
+	req.NoError(ioutil.WriteFile(filepath.Join(inputDir, "installer.yaml"), []byte(installer), 0644))
+	req.NoError(w.convertChanged(map[string]bool{
+		filepath.Join(inputDir, "installer.yaml"): true,
+	}))
+
+	values, err := ioutil.ReadFile(filepath.Join(outputDir, "values.yaml"))
+	req.NoError(err)
+	assert.Contains(t, string(values), "serviceCIDR: 10.96.0.0/22")
+
+	// a config change regenerates the values
+	req.NoError(ioutil.WriteFile(filepath.Join(inputDir, "config.yaml"), []byte(config+"\n        - name: bar\n          type: text"), 0644))
+	req.NoError(w.convertChanged(map[string]bool{
+		filepath.Join(inputDir, "config.yaml"): true,
+	}))
+
+	values, err = ioutil.ReadFile(filepath.Join(outputDir, "values.yaml"))
+	req.NoError(err)
+	assert.Contains(t, string(values), "bar:")
+}
@@ -0,0 +1,191 @@
+package builder
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/replicatedhq/kots2helm/pkg/logger"
+)
+
+// whenFalseValues are the values kots parses as false in a when, compared in lower case
+var whenFalseValues = []string{"n", "no", "false", "off", "0"}
+
+// configWhen is the when of a config group or item that the chart evaluates
+type configWhen struct {
+	// Template is the name of the named template in _helpers.tpl that renders the expression
+	Template string
+	// Description names the group or item, for the comment on the named template
+	Description string
+	// Expression is the helm template converted from the kots one
+	Expression string
+}
+
+// conditionalConfigItem is a config item without a generated or derived value that is
+// hidden by its when, or the when of its group
+type conditionalConfigItem struct {
+	Name string
+	// When is the template condition for the item being shown
+	When string
+	// ValuesReference is the template expression for the item's value in .Values
+	ValuesReference string
+	Bool            bool
+}
+
+func groupWhenTemplate(chartName string, group kotsv1beta1.ConfigGroup) string {
+	return fmt.Sprintf("%s.group.%s.when", chartName, group.Name)
+}
+
+func itemWhenTemplate(chartName string, item kotsv1beta1.ConfigItem) string {
+	return fmt.Sprintf("%s.config.%s.when", chartName, item.Name)
+}
+
+// convertWhen converts the when of a group or item. a when without a template is a constant,
+// and is returned as "true" or "false". a template that can't be converted is ignored, the
+// same as a when that's always true
+func convertWhen(when string, description string, kotsConfig *kotsv1beta1.Config, chartName string, valuesOpts ValuesOpts) (string, bool, error) {
+	if when == "" {
+		return "true", false, nil
+	}
+	if !kotsTemplateRegex.MatchString(when) {
+		for _, v := range whenFalseValues {
+			if strings.ToLower(strings.TrimSpace(when)) == v {
+				return "false", false, nil
+			}
+		}
+		return "true", false, nil
+	}
+
+	expression, err := convertConfigItemExpression(when, kotsConfig, chartName, valuesOpts)
+	if err != nil {
+		return "", false, errors.Wrapf(err, "failed to convert the when of %s", description)
+	}
+	if kotsTemplateRegex.MatchString(expression) {
+		logger.Warnf("the when of %s has template functions that could not be converted, it's ignored", description)
+		return "true", false, nil
+	}
+
+	return expression, true, nil
+}
+
+// getConfigItemCondition returns the template condition for a config item being shown,
+// which is empty when the item is always shown
+func getConfigItemCondition(group kotsv1beta1.ConfigGroup, item kotsv1beta1.ConfigItem, kotsConfig *kotsv1beta1.Config, chartName string, valuesOpts ValuesOpts) (string, error) {
+	conditions := []string{}
+
+	whens := []struct {
+		when        string
+		description string
+		template    string
+	}{
+		{string(group.When), fmt.Sprintf("the %s config group", group.Name), groupWhenTemplate(chartName, group)},
+		{string(item.When), fmt.Sprintf("the %s config item", item.Name), itemWhenTemplate(chartName, item)},
+	}
+	for _, w := range whens {
+		expression, isTemplate, err := convertWhen(w.when, w.description, kotsConfig, chartName, valuesOpts)
+		if err != nil {
+			return "", err
+		}
+		if isTemplate {
+			conditions = append(conditions, fmt.Sprintf(`(include "%s.when" (list . %q))`, chartName, w.template))
+		} else if expression == "false" {
+			return "false", nil
+		}
+	}
+
+	switch len(conditions) {
+	case 0:
+		return "", nil
+	case 1:
+		return strings.TrimSuffix(strings.TrimPrefix(conditions[0], "("), ")"), nil
+	}
+
+	return "and " + strings.Join(conditions, " "), nil
+}
+
+// getConditionalConfigItemNames returns the names of the config items that are hidden by
+// their when or their group's when
+func getConditionalConfigItemNames(kotsConfig *kotsv1beta1.Config, chartName string, valuesOpts ValuesOpts) (map[string]bool, error) {
+	conditional := map[string]bool{}
+
+	for _, group := range kotsConfig.Spec.Groups {
+		for _, item := range group.Items {
+			condition, err := getConfigItemCondition(group, item, kotsConfig, chartName, valuesOpts)
+			if err != nil {
+				return nil, err
+			}
+			if condition != "" {
+				conditional[item.Name] = true
+			}
+		}
+	}
+
+	return conditional, nil
+}
+
+// getConfigWhens returns the when expressions of the groups and items that the chart
+// evaluates, in config order
+func getConfigWhens(kotsConfig *kotsv1beta1.Config, chartName string, valuesOpts ValuesOpts) ([]configWhen, error) {
+	whens := []configWhen{}
+
+	for _, group := range kotsConfig.Spec.Groups {
+		description := fmt.Sprintf("the %s config group", group.Name)
+		expression, isTemplate, err := convertWhen(string(group.When), description, kotsConfig, chartName, valuesOpts)
+		if err != nil {
+			return nil, err
+		}
+		if isTemplate {
+			whens = append(whens, configWhen{
+				Template:    groupWhenTemplate(chartName, group),
+				Description: description,
+				Expression:  expression,
+			})
+		}
+
+		for _, item := range group.Items {
+			description := fmt.Sprintf("the %s config item", item.Name)
+			expression, isTemplate, err := convertWhen(string(item.When), description, kotsConfig, chartName, valuesOpts)
+			if err != nil {
+				return nil, err
+			}
+			if isTemplate {
+				whens = append(whens, configWhen{
+					Template:    itemWhenTemplate(chartName, item),
+					Description: description,
+					Expression:  expression,
+				})
+			}
+		}
+	}
+
+	return whens, nil
+}
+
+// getConditionalConfigItems returns the conditional config items that need a named template
+// of their own. random and derived items have one already, which checks the condition too
+func getConditionalConfigItems(kotsConfig *kotsv1beta1.Config, chartName string, valuesOpts ValuesOpts) ([]conditionalConfigItem, error) {
+	conditionalItems := []conditionalConfigItem{}
+
+	for _, group := range kotsConfig.Spec.Groups {
+		for _, item := range group.Items {
+			if !valuesOpts.Conditional[item.Name] || valuesOpts.Derived[item.Name] || isRandomConfigItem(group, item) {
+				continue
+			}
+
+			condition, err := getConfigItemCondition(group, item, kotsConfig, chartName, valuesOpts)
+			if err != nil {
+				return nil, err
+			}
+
+			conditionalItems = append(conditionalItems, conditionalConfigItem{
+				Name:            item.Name,
+				When:            condition,
+				ValuesReference: operand(guardConfigItemValue(chartName, item, valuesOpts, configItemValuesReference(group, item, valuesOpts))),
+				Bool:            item.Type == "bool",
+			})
+		}
+	}
+
+	return conditionalItems, nil
+}
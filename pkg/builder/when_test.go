@@ -0,0 +1,89 @@
+package builder
+
+import (
+	"testing"
+
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/replicatedhq/kots/kotskinds/multitype"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_getConfigItemCondition(t *testing.T) {
+	kotsConfig := &kotsv1beta1.Config{
+		Spec: kotsv1beta1.ConfigSpec{
+			Groups: []kotsv1beta1.ConfigGroup{
+				{
+					Name: "db",
+					Items: []kotsv1beta1.ConfigItem{
+						{Name: "db_type", Type: "select_one", Default: multitype.FromString("embedded")},
+						{Name: "external_host", Type: "text", When: `repl{{ ConfigOptionEquals "db_type" "external" }}`},
+						{Name: "legacy", Type: "text", When: "false"},
+						{Name: "shown", Type: "text", When: "true"},
+						{Name: "licensed", Type: "text", When: `repl{{ LicenseFieldValue "foo" }}`},
+					},
+				},
+				{
+					Name: "smtp",
+					When: `{{repl ConfigOption "smtp_enabled" }}`,
+					Items: []kotsv1beta1.ConfigItem{
+						{Name: "smtp_enabled", Type: "bool"},
+						{Name: "smtp_host", Type: "text", When: `{{repl ConfigOption "smtp_enabled" }}`},
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		group  int
+		item   int
+		expect string
+	}{
+		{group: 0, item: 0, expect: ""},
+		{group: 0, item: 1, expect: `include "app.when" (list . "app.config.external_host.when")`},
+		{group: 0, item: 2, expect: "false"},
+		{group: 0, item: 3, expect: ""},
+		{group: 0, item: 4, expect: ""},
+		{group: 1, item: 0, expect: `include "app.when" (list . "app.group.smtp.when")`},
+		{group: 1, item: 1, expect: `and (include "app.when" (list . "app.group.smtp.when")) (include "app.when" (list . "app.config.smtp_host.when"))`},
+	}
+	for _, tt := range tests {
+		group := kotsConfig.Spec.Groups[tt.group]
+		item := group.Items[tt.item]
+		t.Run(item.Name, func(t *testing.T) {
+			condition, err := getConfigItemCondition(group, item, kotsConfig, "app", ValuesOpts{})
+			require.NoError(t, err)
+			assert.Equal(t, tt.expect, condition)
+		})
+	}
+
+	conditional, err := getConditionalConfigItemNames(kotsConfig, "app", ValuesOpts{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]bool{
+		"external_host": true,
+		"legacy":        true,
+		"smtp_enabled":  true,
+		"smtp_host":     true,
+	}, conditional)
+
+	whens, err := getConfigWhens(kotsConfig, "app", ValuesOpts{})
+	require.NoError(t, err)
+	assert.Equal(t, []configWhen{
+		{
+			Template:    "app.config.external_host.when",
+			Description: "the external_host config item",
+			Expression:  `{{ if eq (dig "db" "db_type" "embedded" .Values.AsMap) "external" }}true{{ else }}false{{ end }}`,
+		},
+		{
+			Template:    "app.group.smtp.when",
+			Description: "the smtp config group",
+			Expression:  `{{ ternary "1" "0" (dig "smtp" "smtp_enabled" false .Values.AsMap) }}`,
+		},
+		{
+			Template:    "app.config.smtp_host.when",
+			Description: "the smtp_host config item",
+			Expression:  `{{ ternary "1" "0" (dig "smtp" "smtp_enabled" false .Values.AsMap) }}`,
+		},
+	}, whens)
+}
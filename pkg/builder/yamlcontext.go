@@ -0,0 +1,192 @@
+package builder
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+)
+
+var (
+	yamlBlockScalarHeaderRegex = regexp.MustCompile(`(?:^|[:-]\s+)[|>][-+1-9]*\s*(?:#.*)?$`)
+	yamlValuePrefixRegex       = regexp.MustCompile(`^\s*(?:-\s+)*(?:(?:"[^"]*"|'[^']*'|[^\s:#{}\[\],]+)\s*:\s+)?$`)
+	yamlValueSuffixRegex       = regexp.MustCompile(`^\s*(?:#.*)?$`)
+)
+
+// configReferenceInsertion is a converted ConfigOption in a line of yaml
+type configReferenceInsertion struct {
+	start     int
+	end       int
+	reference string
+}
+
+// insertConfigReferences makes the converted ConfigOption actions safe for where they are
+// in the yaml. kots pastes the value as it is, so a multi-line value breaks the indent and
+// a value with ": " or " #" changes the structure of the document:
+//   - in a block scalar, each line of the value is indented to the block
+//   - a whole line without a key is yaml itself, and is indented with nindent
+//   - in a quoted scalar, the value is escaped for the quotes
+//   - a whole plain scalar is quoted when it isn't a safe plain scalar
+//
+// bools render as 1 and 0 so they're always safe
+func insertConfigReferences(content []byte, kotsConfig *kotsv1beta1.Config, chartName string, valuesOpts ValuesOpts) []byte {
+	references := []string{}
+	for _, group := range kotsConfig.Spec.Groups {
+		for _, item := range group.Items {
+			if item.Type == "bool" {
+				continue
+			}
+			reference, err := getReferenceForConfigItem(item.Name, kotsConfig, chartName, valuesOpts)
+			if err != nil {
+				continue
+			}
+			references = append(references, reference)
+		}
+	}
+	if len(references) == 0 {
+		return content
+	}
+
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		insertions := []configReferenceInsertion{}
+		for _, reference := range references {
+			action := fmt.Sprintf("{{ %s }}", reference)
+			for offset := 0; ; {
+				idx := strings.Index(line[offset:], action)
+				if idx == -1 {
+					break
+				}
+				start := offset + idx
+				insertions = append(insertions, configReferenceInsertion{
+					start:     start,
+					end:       start + len(action),
+					reference: reference,
+				})
+				offset = start + len(action)
+			}
+		}
+		if len(insertions) == 0 {
+			continue
+		}
+
+		// replace from the end of the line so the earlier positions don't move
+		sort.Slice(insertions, func(a, b int) bool {
+			return insertions[a].start > insertions[b].start
+		})
+		for _, insertion := range insertions {
+			action := configReferenceAction(lines, i, line, insertion, chartName)
+			line = line[:insertion.start] + action + line[insertion.end:]
+		}
+		lines[i] = line
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
+
+// configReferenceAction returns the helm template action for a reference at its position
+// in the line
+func configReferenceAction(lines []string, idx int, line string, insertion configReferenceInsertion, chartName string) string {
+	original := line[insertion.start:insertion.end]
+	prefix := line[:insertion.start]
+	suffix := line[insertion.end:]
+	indent := len(line) - len(strings.TrimLeft(line, " "))
+	isWholeLine := strings.TrimSpace(prefix) == "" && strings.TrimSpace(suffix) == ""
+
+	if isInYAMLBlockScalar(lines, idx) {
+		if isWholeLine && idx > 0 && strings.TrimSpace(lines[idx-1]) != "" {
+			return fmt.Sprintf("{{- %s | nindent %d }}", insertion.reference, indent)
+		}
+		return fmt.Sprintf(`{{ %s | replace "\n" "\n%s" }}`, insertion.reference, strings.Repeat(" ", indent))
+	}
+
+	switch yamlQuoteAt(line, insertion.start) {
+	case '"':
+		return fmt.Sprintf(`{{ %s | quote | trimPrefix "\"" | trimSuffix "\"" }}`, insertion.reference)
+	case '\'':
+		return fmt.Sprintf(`{{ %s | replace "'" "''" }}`, insertion.reference)
+	}
+
+	if isWholeLine {
+		return fmt.Sprintf("{{- %s | nindent %d }}", insertion.reference, indent)
+	}
+	if yamlValuePrefixRegex.MatchString(prefix) && yamlValueSuffixRegex.MatchString(suffix) {
+		return fmt.Sprintf(`{{ include "%s.yamlScalar" %s }}`, chartName, operand(insertion.reference))
+	}
+
+	// part of a plain scalar, which can't be quoted
+	return original
+}
+
+// isInYAMLBlockScalar is true when line idx is in the content of a | or > block scalar
+func isInYAMLBlockScalar(lines []string, idx int) bool {
+	indent := len(lines[idx]) - len(strings.TrimLeft(lines[idx], " "))
+
+	for i := idx - 1; i >= 0 && indent > 0; i-- {
+		lineIndent, ok := yamlLineIndent(lines[i])
+		if !ok || len(lineIndent) >= indent {
+			continue
+		}
+
+		if yamlBlockScalarHeaderRegex.MatchString(strings.TrimRight(lines[i], " ")) {
+			return true
+		}
+		indent = len(lineIndent)
+	}
+
+	return false
+}
+
+// yamlQuoteAt returns the quote of the scalar that pos is in, or 0 when it isn't in a
+// quoted scalar. template actions are skipped, they can contain quotes of their own
+func yamlQuoteAt(line string, pos int) byte {
+	var quote byte
+	for i := 0; i < pos; i++ {
+		if strings.HasPrefix(line[i:], "{{") {
+			end := strings.Index(line[i:], "}}")
+			if end == -1 {
+				return quote
+			}
+			i += end + 1
+			continue
+		}
+
+		c := line[i]
+		switch {
+		case quote == '"' && c == '\\':
+			i++
+		case quote == '"' && c == '"':
+			quote = 0
+		case quote == '\'' && c == '\'':
+			if i+1 < len(line) && line[i+1] == '\'' {
+				i++
+			} else {
+				quote = 0
+			}
+		case quote == 0 && (c == '"' || c == '\'') && isYAMLScalarStart(line, i):
+			quote = c
+		}
+	}
+
+	return quote
+}
+
+// isYAMLScalarStart is true when a scalar can start at pos, so a quote there opens a
+// quoted scalar instead of being part of a plain one
+func isYAMLScalarStart(line string, pos int) bool {
+	before := strings.TrimRight(line[:pos], " ")
+	if before == "" {
+		return true
+	}
+
+	switch before[len(before)-1] {
+	case ':', '-', '?':
+		return pos > len(before)
+	case '[', '{', ',':
+		return true
+	}
+
+	return false
+}
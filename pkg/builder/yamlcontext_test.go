@@ -0,0 +1,108 @@
+package builder
+
+import (
+	"testing"
+
+	kotsv1beta1 "github.com/replicatedhq/kots/kotskinds/apis/kots/v1beta1"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_insertConfigReferences(t *testing.T) {
+	kotsConfig := &kotsv1beta1.Config{
+		Spec: kotsv1beta1.ConfigSpec{
+			Groups: []kotsv1beta1.ConfigGroup{
+				{
+					Name: "group1",
+					Items: []kotsv1beta1.ConfigItem{
+						{Name: "cert", Type: "textarea"},
+						{Name: "name", Type: "text"},
+						{Name: "enabled", Type: "bool"},
+					},
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		expect  string
+	}{
+		{
+			name:    "plain scalar",
+			content: `name: {{ dig "group1" "name" "" .Values.AsMap }}`,
+			expect:  `name: {{ include "test.yamlScalar" (dig "group1" "name" "" .Values.AsMap) }}`,
+		},
+		{
+			name:    "plain scalar list item with a comment",
+			content: `- {{ dig "group1" "name" "" .Values.AsMap }} # the name`,
+			expect:  `- {{ include "test.yamlScalar" (dig "group1" "name" "" .Values.AsMap) }} # the name`,
+		},
+		{
+			name:    "part of a plain scalar",
+			content: `image: repo/app:{{ dig "group1" "name" "" .Values.AsMap }}`,
+			expect:  `image: repo/app:{{ dig "group1" "name" "" .Values.AsMap }}`,
+		},
+		{
+			name:    "double quoted",
+			content: `name: "{{ dig "group1" "name" "" .Values.AsMap }}-{{ dig "group1" "name" "" .Values.AsMap }}"`,
+			expect:  `name: "{{ dig "group1" "name" "" .Values.AsMap | quote | trimPrefix "\"" | trimSuffix "\"" }}-{{ dig "group1" "name" "" .Values.AsMap | quote | trimPrefix "\"" | trimSuffix "\"" }}"`,
+		},
+		{
+			name:    "single quoted after an action with quotes",
+			content: `name: '{{ include "x" . }}{{ dig "group1" "name" "" .Values.AsMap }}'`,
+			expect:  `name: '{{ include "x" . }}{{ dig "group1" "name" "" .Values.AsMap | replace "'" "''" }}'`,
+		},
+		{
+			name:    "apostrophe in a plain scalar",
+			content: `name: don't {{ dig "group1" "name" "" .Values.AsMap }}`,
+			expect:  `name: don't {{ dig "group1" "name" "" .Values.AsMap }}`,
+		},
+		{
+			name: "block scalar",
+			content: `data:
+  cert.pem: |-
+    {{ dig "group1" "cert" "" .Values.AsMap }}
+  config.ini: |
+    [server]
+      cert = {{ dig "group1" "cert" "" .Values.AsMap }}`,
+			expect: `data:
+  cert.pem: |-
+    {{- dig "group1" "cert" "" .Values.AsMap | nindent 4 }}
+  config.ini: |
+    [server]
+      cert = {{ dig "group1" "cert" "" .Values.AsMap | replace "\n" "\n      " }}`,
+		},
+		{
+			name: "block scalar after a blank line",
+			content: `script: |
+  echo
+
+  {{ dig "group1" "cert" "" .Values.AsMap }}`,
+			expect: `script: |
+  echo
+
+  {{ dig "group1" "cert" "" .Values.AsMap | replace "\n" "\n  " }}`,
+		},
+		{
+			name: "whole line",
+			content: `resources:
+  {{ dig "group1" "cert" "" .Values.AsMap }}
+name: x`,
+			expect: `resources:
+  {{- dig "group1" "cert" "" .Values.AsMap | nindent 2 }}
+name: x`,
+		},
+		{
+			name:    "bool",
+			content: `enabled: {{ ternary "1" "0" dig "group1" "enabled" false .Values.AsMap }}`,
+			expect:  `enabled: {{ ternary "1" "0" dig "group1" "enabled" false .Values.AsMap }}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := insertConfigReferences([]byte(tt.content), kotsConfig, "test", ValuesOpts{})
+			assert.Equal(t, tt.expect, string(actual))
+		})
+	}
+}